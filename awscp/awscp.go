@@ -32,7 +32,55 @@ type AwsS3cp struct {
 	client    *awss3.S3
 	file      *os.File
 	fileinfo  os.FileInfo
+	md5sum    string
+	uploadSum string // md5sum or multipart etag of the uploaded data
 	WorkNum   int
+
+	// Server-side encryption: SSE is "AES256" or "aws:kms".
+	// SSECustomerKey is a raw 32 byte key for SSE-C and excludes SSE.
+	SSE            string
+	SSEKMSKeyId    string
+	SSECustomerKey string
+
+	// StorageClass is empty for the bucket default (STANDARD).
+	StorageClass string
+
+	// Headers holds Cache-Control, Content-Encoding, Content-Disposition,
+	// Content-Language and Expires; Metadata is sent as x-amz-meta-*.
+	Headers  map[string]string
+	Metadata map[string]string
+
+	// Tags are set on upload; with Retag the tags of unchanged objects are updated.
+	Tags     map[string]string
+	Retag    bool
+	Retagged bool
+
+	// Source object of S3Copy. The headers and metadata of the source are
+	// preserved unless ReplaceMetadata is set.
+	SrcBucket       string
+	SrcKey          string
+	ReplaceMetadata bool
+
+	// Compress is "gzip" or "zstd". The object gets Content-Encoding, or
+	// the ".gz"/".zst" suffix with CompressSuffix.
+	Compress       string
+	CompressSuffix bool
+	originalMd5    string
+
+	// EncryptKey enables client-side encryption (see cse.go).
+	EncryptKey []byte
+
+	// Compare is CompareSize (the default, CheckSize and CheckMD5) or
+	// CompareMtime. The mtime is always stored in metadata; PreserveMode
+	// stores the mode and owner as well.
+	Compare      string
+	PreserveMode bool
+
+	// Checksums caches the md5sum and multipart etags of the files.
+	Checksums ChecksumCache
+
+	// SymlinkTarget uploads FilePath as a symlink (see symlink.go).
+	SymlinkTarget string
 }
 
 type PartListError struct {
@@ -67,6 +115,7 @@ type putWork struct {
 type result struct {
 	err  error
 	part s3.CompletedPart
+	sum  []byte // md5sum of the part
 }
 
 type ReaderAtSeeker interface {
@@ -74,11 +123,31 @@ type ReaderAtSeeker interface {
 	io.ReadSeeker
 }
 
+// ValidateStorageClass checks the name against the storage classes known to S3.
+func ValidateStorageClass(class string) error {
+	for _, c := range s3.StorageClass_Values() {
+		if class == c {
+			return nil
+		}
+	}
+	return errors.New("unknown storage class: " + class)
+}
+
 func (a *AwsS3cp) SetS3client(s *s3.S3) {
 	a.client = &awss3.S3{S3: *s}
 }
 
+func (a *AwsS3cp) workNum() int {
+	if a.WorkNum < 1 {
+		return 1
+	}
+	return a.WorkNum
+}
+
 func (a *AwsS3cp) FileUpload() (upload bool, err error) {
+	if a.SymlinkTarget != "" {
+		return a.symlinkUpload()
+	}
 	upload = false
 	a.file, err = os.Open(a.FilePath)
 	if err != nil {
@@ -90,8 +159,25 @@ func (a *AwsS3cp) FileUpload() (upload bool, err error) {
 		return
 	}
 
+	a.setFileMetadata()
+	if a.Compress != "" {
+		a.setCompressPath()
+	}
 	err = a.CompareFile()
 	if err == nil {
+		if a.Retag {
+			a.Retagged, err = a.UpdateTags()
+		}
+		return
+	}
+	if a.Compress != "" {
+		err = a.compressUpload()
+		upload = err == nil
+		return
+	}
+	if a.EncryptKey != nil {
+		err = a.encryptUpload()
+		upload = err == nil
 		return
 	}
 	if size, _ := file.FileSize(a.FilePath); size > a.PartSize {
@@ -114,23 +200,36 @@ func (a *AwsS3cp) FileUpload() (upload bool, err error) {
 }
 
 func (a *AwsS3cp) CompareFile() error {
-	md5sum := ""
-	var err error
+	if a.Compare == CompareMtime {
+		return a.compareMtime()
+	}
+	if a.Compress != "" || a.EncryptKey != nil {
+		return a.compareOriginal()
+	}
 	size := a.fileinfo.Size()
 	if !a.CheckSize {
 		size = 0
 	}
-	if a.CheckMD5 {
-		if size > a.PartSize {
-			md5sum, err = MultipartEtag(a.file, a.PartSize)
-		} else {
-			md5sum, err = file.Md5sum(a.file)
+	res, err := a.head()
+	if err == nil {
+		err = a.compareObject(res, size, "")
+	}
+	// Hash the file only to compare with an object of the same size, or for
+	// the metadata of an upload whose ETag will not be the md5sum.
+	if a.CheckMD5 && (err == nil || a.md5InMetadata() || res != nil && !etagIsMD5(res)) {
+		partSize := int64(0)
+		if a.fileinfo.Size() > a.PartSize {
+			partSize = a.PartSize
 		}
-		if err != nil {
+		if a.md5sum, err = a.fileSum(partSize); err != nil {
 			return err
 		}
+		if res == nil {
+			return &S3NotExistsError{a.S3Path}
+		}
+		return a.compareObject(res, size, a.md5sum)
 	}
-	return a.Exists(size, md5sum)
+	return err
 }
 
 type S3NotExistsError struct {
@@ -175,12 +274,47 @@ func (e *S3MD5sumIsDifferentError) Error() string {
 	return fmt.Sprintf("%s is %s  != %s", e.S3Path, e.S3md5, e.Md5)
 }
 
-func (a *AwsS3cp) Exists(size int64, md5sum string) error {
+type S3StorageClassIsDifferentError struct {
+	S3Path         string
+	S3StorageClass string
+	StorageClass   string
+}
+
+func (e *S3StorageClassIsDifferentError) Error() string {
+	return fmt.Sprintf("%s storage class is %s != %s", e.S3Path, e.S3StorageClass, e.StorageClass)
+}
+
+// ChecksumCache is a cache of the md5sum (partSize 0) and multipart etags of files.
+type ChecksumCache interface {
+	Get(path string, fi os.FileInfo, partSize int64) (string, bool)
+	Set(path string, fi os.FileInfo, partSize int64, sum string)
+}
+
+// fileSum returns the md5sum of the file, or the multipart etag for partSize > 0.
+func (a *AwsS3cp) fileSum(partSize int64) (sum string, err error) {
+	if a.Checksums != nil {
+		if sum, ok := a.Checksums.Get(a.FilePath, a.fileinfo, partSize); ok {
+			return sum, nil
+		}
+	}
+	if partSize > 0 {
+		sum, err = MultipartEtag(a.file, partSize)
+	} else {
+		sum, err = file.Md5sum(a.file)
+	}
+	if err == nil && a.Checksums != nil {
+		a.Checksums.Set(a.FilePath, a.fileinfo, partSize, sum)
+	}
+	return sum, err
+}
+
+func (a *AwsS3cp) head() (*s3.HeadObjectOutput, error) {
 	req := s3.HeadObjectInput{
 		Bucket: &a.Bucket, // aws.StringValue  `xml:"-"`
 		Key:    &a.S3Path, // aws.StringValue  `xml:"-"`
 
 	}
+	a.setHeadObjectSSE(&req)
 	//pp.Print(req)
 	res, err := a.client.HeadObject(&req)
 	/*
@@ -193,16 +327,48 @@ func (a *AwsS3cp) Exists(size int64, md5sum string) error {
 		}
 	*/
 	if res == nil || err != nil {
-		return &S3NotExistsError{a.S3Path}
+		return nil, &S3NotExistsError{a.S3Path}
 	}
+	return res, nil
+}
+
+func (a *AwsS3cp) Exists(size int64, md5sum string) error {
+	res, err := a.head()
+	if err != nil {
+		return err
+	}
+	return a.compareObject(res, size, md5sum)
+}
+
+func (a *AwsS3cp) compareObject(res *s3.HeadObjectOutput, size int64, md5sum string) error {
 	if size > 0 && *res.ContentLength != size {
 		return &S3FileSizeIsDifferentError{a.S3Path, *res.ContentLength, size}
 	}
 	if md5sum != "" {
 		md5 := `"` + md5sum + `"`
-		if *res.ETag != md5 {
-			return &S3MD5sumIsDifferentError{a.S3Path, *res.ETag, md5}
+		etag := aws.StringValue(res.ETag)
+		if !etagIsMD5(res) {
+			// SSE-KMS and SSE-C objects have no MD5 ETag; use the md5 stored at upload.
+			etag = `"` + metaValue(res.Metadata, MetaMD5) + `"`
 		}
+		if etag != md5 {
+			return &S3MD5sumIsDifferentError{a.S3Path, etag, md5}
+		}
+	}
+	return a.compareStorageClass(res)
+}
+
+func (a *AwsS3cp) compareStorageClass(res *s3.HeadObjectOutput) error {
+	if a.StorageClass == "" {
+		return nil
+	}
+	// HEAD omits x-amz-storage-class for STANDARD.
+	class := aws.StringValue(res.StorageClass)
+	if class == "" {
+		class = s3.StorageClassStandard
+	}
+	if class != a.StorageClass {
+		return &S3StorageClassIsDifferentError{a.S3Path, class, a.StorageClass}
 	}
 	return nil
 }
@@ -233,19 +399,17 @@ func (a *AwsS3cp) ParallelPutAll(r *os.File, partSize int64, parallel int) ([]s3
 			return nil, err
 		}
 	}
+	if a.UploadId != nil && !a.resumable() {
+		// The pending upload was created without the options of this one,
+		// which CompleteMultipartUpload would silently drop.
+		a.Log.Info("abort old UploadId:%s", *a.UploadId)
+		a.abortMultipartUpload()
+		a.UploadId = nil
+	}
 	if a.UploadId != nil {
 		a.Log.Debug("old UploadId:%s", *a.UploadId)
-	} else {
-		req := &s3.CreateMultipartUploadInput{
-			Bucket: aws.String(a.Bucket),
-			Key:    aws.String(a.S3Path),
-		}
-		resp, err := a.client.CreateMultipartUpload(req)
-		if err != nil {
-			return nil, err
-		}
-		a.UploadId = resp.UploadId
-		a.Log.Debug("Create UploadId:%s", *a.UploadId)
+	} else if err = a.createMultipartUpload(); err != nil {
+		return nil, err
 	}
 	a.Log.Debug("S3Path = %s", a.S3Path)
 	listReq := &s3.ListPartsInput{
@@ -272,6 +436,7 @@ func (a *AwsS3cp) ParallelPutAll(r *os.File, partSize int64, parallel int) ([]s3
 		return nil, err
 	}
 	totalSize := finfo.Size()
+	etagPartSize := partSize
 	first := true // Must send at least one empty part if the file is empty.
 
 	done := make(chan struct{})
@@ -313,27 +478,55 @@ func (a *AwsS3cp) ParallelPutAll(r *os.File, partSize int64, parallel int) ([]s3
 	}()
 
 	resultMap := []s3.CompletedPart{}
+	sums := map[int64][]byte{}
 	for res := range workResults {
 		if res.err != nil {
 			err = errors.New(fmt.Sprintf("%s [part:%d err:%v]", err, res.part.PartNumber, res.err))
 		} else {
 			resultMap = append(resultMap, res.part)
+			sums[*res.part.PartNumber] = res.sum
 		}
 	}
+	if err == nil {
+		a.setMultipartEtag(sums, etagPartSize)
+	}
 
 	return resultMap, err
 }
 
+// setMultipartEtag builds the multipart etag from the md5sums of the
+// uploaded parts for VerifyUpload, and adds it to the checksum cache so the
+// next run need not hash the file.
+func (a *AwsS3cp) setMultipartEtag(sums map[int64][]byte, partSize int64) {
+	h := md5.New()
+	for n := int64(1); n <= int64(len(sums)); n++ {
+		sum, ok := sums[n]
+		if !ok {
+			return
+		}
+		h.Write(sum)
+	}
+	a.uploadSum = fmt.Sprintf("%s-%d", hex.EncodeToString(h.Sum(nil)), len(sums))
+	if a.Checksums != nil && a.fileinfo != nil {
+		a.Checksums.Set(a.FilePath, a.fileinfo, partSize, a.uploadSum)
+	}
+}
+
+// PutWorker uploads the parts of queue. Each part is read once into a
+// shared part buffer, and hashed and uploaded from memory.
 func (a *AwsS3cp) PutWorker(done chan struct{}, queue <-chan putWork, r chan<- result, end chan<- int) {
 	count := 0
 	for w := range queue {
 		res := result{}
-		size, md5hex, _, err := seekerInfo(w.section)
+		buf := getBuffer(w.partSize)
+		body, sum, err := readPart(w.section, w.partSize, *buf)
 		if err != nil {
-			a.Log.Warning("SeekInfo err: %v", err)
+			a.Log.Warning("read part err: %v", err)
 			res.err = err
+			res.part.PartNumber = aws.Int64(w.current)
 		} else {
-			etag := `"` + md5hex + `"`
+			res.sum = sum
+			etag := `"` + hex.EncodeToString(sum) + `"`
 			if w.existOld && *w.oldpart.Size == w.partSize && *w.oldpart.ETag == etag {
 				a.Log.Info("Already upload Part: %v", w.oldpart)
 				res.part = s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(w.current)}
@@ -341,21 +534,21 @@ func (a *AwsS3cp) PutWorker(done chan struct{}, queue <-chan putWork, r chan<- r
 				// Part wasn't found or doesn't match. Send it.
 				a.Log.Info("Start upload Part section Num:%d", w.current)
 				req := s3.UploadPartInput{
-					Body:          w.section,            // io.ReadCloser    `xml:"-"`
-					Bucket:        aws.String(a.Bucket), // aws.StringValue  `xml:"-"`
-					ContentLength: aws.Int64(size),      // aws.LongValue    `xml:"-"`
-					//ContentMD5:    aws.String(md5b64),     // aws.StringValue  `xml:"-"`
-					Key:        aws.String(a.S3Path), // aws.StringValue  `xml:"-"`
-					PartNumber: aws.Int64(w.current), // aws.IntegerValue `xml:"-"`
-					UploadId:   a.UploadId,           // aws.StringValue  `xml:"-"`
+					Body:          bytes.NewReader(body),                              // io.ReadCloser    `xml:"-"`
+					Bucket:        aws.String(a.Bucket),                               // aws.StringValue  `xml:"-"`
+					ContentLength: aws.Int64(w.partSize),                              // aws.LongValue    `xml:"-"`
+					ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum)), // aws.StringValue  `xml:"-"`
+					Key:           aws.String(a.S3Path),                               // aws.StringValue  `xml:"-"`
+					PartNumber:    aws.Int64(w.current),                               // aws.IntegerValue `xml:"-"`
+					UploadId:      a.UploadId,                                         // aws.StringValue  `xml:"-"`
 				}
+				a.setUploadPartSSE(&req)
 				resp, err := a.client.UploadPart(&req)
 				res.err = err
 				res.part = s3.CompletedPart{
 					ETag:       resp.ETag,
 					PartNumber: req.PartNumber,
 				}
-				//res.part, res.err = a.multi.PutPart(w.current, w.section)
 				if err != nil {
 					a.Log.Warning("UploadPart err Part Num:%d err: %v", w.current, res.err)
 				} else {
@@ -363,6 +556,7 @@ func (a *AwsS3cp) PutWorker(done chan struct{}, queue <-chan putWork, r chan<- r
 				}
 			}
 		}
+		putBuffer(buf)
 		select {
 		case r <- res:
 		case <-done:
@@ -374,23 +568,6 @@ func (a *AwsS3cp) PutWorker(done chan struct{}, queue <-chan putWork, r chan<- r
 	end <- count
 }
 
-func seekerInfo(r io.ReadSeeker) (size int64, md5hex string, md5b64 string, err error) {
-	_, err = r.Seek(0, 0)
-	if err != nil {
-		return 0, "", "", err
-	}
-	digest := md5.New()
-	size, err = io.Copy(digest, r)
-	if err != nil {
-		return 0, "", "", err
-	}
-	sum := digest.Sum(nil)
-	md5hex = hex.EncodeToString(sum)
-	md5b64 = base64.StdEncoding.EncodeToString(sum)
-	_, err = r.Seek(0, 0)
-	return size, md5hex, md5b64, nil
-}
-
 func (a *AwsS3cp) S3ParallelMultipartUpload(parallel int) ([]s3.CompletedPart, error) {
 	var err error
 	//bucket := a.client.Bucket(a.Bucket)
@@ -403,12 +580,58 @@ func (a *AwsS3cp) S3ParallelMultipartUpload(parallel int) ([]s3.CompletedPart, e
 		return nil, err
 	}
 	a.Log.Debug("uploaded all Parts. len(parts)=%v", len(parts))
+	if err = a.completeMultipartUpload(parts); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// resumable reports whether a pending upload of the key can be resumed.
+// The options given to CreateMultipartUpload can not be read back from a
+// pending upload, so only an upload without encryption, storage class,
+// headers, tags or metadata other than the mtime is resumed.
+func (a *AwsS3cp) resumable() bool {
+	if a.SSE != "" || a.SSEKMSKeyId != "" || a.SSECustomerKey != "" || a.StorageClass != "" {
+		return false
+	}
+	if len(a.Headers) > 0 || len(a.Tags) > 0 || a.md5sum != "" {
+		return false
+	}
+	for k := range a.Metadata {
+		if k != MetaMtime {
+			return false
+		}
+	}
+	return true
+}
 
+func (a *AwsS3cp) createMultipartUpload() error {
+	req := &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(a.Bucket),
+		Key:      aws.String(a.S3Path),
+		Metadata: a.metadata(),
+	}
+	if a.StorageClass != "" {
+		req.StorageClass = aws.String(a.StorageClass)
+	}
+	req.Tagging = a.tagging()
+	a.setCreateMultipartUploadHeaders(req)
+	a.setCreateMultipartUploadSSE(req)
+	resp, err := a.client.CreateMultipartUpload(req)
+	if err != nil {
+		return err
+	}
+	a.UploadId = resp.UploadId
+	a.Log.Debug("Create UploadId:%s", *a.UploadId)
+	return nil
+}
+
+func (a *AwsS3cp) completeMultipartUpload(parts []s3.CompletedPart) error {
 	partsArray := make([]*s3.CompletedPart, len(parts))
 	for i, p := range parts {
 		if *p.PartNumber > int64(len(partsArray)) || *p.PartNumber <= 0 {
 			a.Log.Debug("Err: [part Number > len(parts) or <=0] parts: %v", parts)
-			return nil, errors.New("part Number > len(parts) or <=0")
+			return errors.New("part Number > len(parts) or <=0")
 		}
 		partsArray[*p.PartNumber-1] = &parts[i]
 	}
@@ -424,24 +647,45 @@ func (a *AwsS3cp) S3ParallelMultipartUpload(parallel int) ([]s3.CompletedPart, e
 			Parts: partsArray,
 		}, // *CompletedMultipartUpload `xml:"CompleteMultipartUpload,omitempty"`
 	}
-	_, err = a.client.CompleteMultipartUpload(&req)
+	_, err := a.client.CompleteMultipartUpload(&req)
 	//pp.Print(req)
 	if err != nil {
 		a.Log.Error("complete err: %# v", err)
-		return nil, err
 	}
-	return parts, err
+	return err
 }
 
+// S3Upload uploads the file by PutObject, reading it once into memory.
 func (a *AwsS3cp) S3Upload(size int64) error {
+	buf := getBuffer(size)
+	defer putBuffer(buf)
+	body, sum, err := readPart(a.file, size, *buf)
+	if err != nil {
+		return err
+	}
+	a.uploadSum = hex.EncodeToString(sum)
+	if a.Checksums != nil {
+		a.Checksums.Set(a.FilePath, a.fileinfo, 0, a.uploadSum)
+	}
+	return a.putObject(bytes.NewReader(body), size)
+}
+
+func (a *AwsS3cp) putObject(body io.ReadSeeker, size int64) error {
 	req := s3.PutObjectInput{
 		Bucket:        aws.String(a.Bucket),   // aws.StringValue   `xml:"-"`
 		Key:           aws.String(a.S3Path),   // aws.StringValue   `xml:"-"`
 		ACL:           aws.String(a.Acl),      // aws.StringValue   `xml:"-"`
 		ContentLength: &size,                  // aws.LongValue     `xml:"-"`
 		ContentType:   aws.String(a.MimeType), // aws.StringValue   `xml:"-"`
-		Body:          a.file,                 // io.ReadCloser     `xml:"-"`
+		Body:          body,                   // io.ReadCloser     `xml:"-"`
+		Metadata:      a.metadata(),
+	}
+	if a.StorageClass != "" {
+		req.StorageClass = aws.String(a.StorageClass)
 	}
+	req.Tagging = a.tagging()
+	a.setPutObjectHeaders(&req)
+	a.setPutObjectSSE(&req)
 	//key := fmt.Sprintf( "%s%s", a.S3Path, path.Base(a.FilePath),)
 	_, err := a.client.PutObject(&req)
 	if err != nil {
@@ -477,14 +721,17 @@ func MultipartEtag(r io.ReadSeeker, partsize int64) (string, error) {
 	var breakFlg bool
 	for {
 		h := md5.New()
-		_, err := io.CopyN(h, r, partsize)
-		i++
+		n, err := io.CopyN(h, r, partsize)
 		if err != nil {
 			if err != io.EOF {
 				return "", err
 			}
+			if n == 0 && i > 0 {
+				break // the size is a multiple of partsize, no empty last part
+			}
 			breakFlg = true
 		}
+		i++
 		if _, err := md5Buf.Write(h.Sum(nil)); err != nil {
 			return "", err
 		}
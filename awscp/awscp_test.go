@@ -0,0 +1,122 @@
+package awscp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/masahide/s3cp/logger"
+)
+
+func TestMultipartEtag(t *testing.T) {
+	const partSize = 5
+	for _, size := range []int{0, 1, partSize, partSize * 2, partSize*2 + 1} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		// The parts of ParallelPutAll: one empty part for an empty file.
+		var sums []byte
+		n := 0
+		for off := 0; off < size || n == 0; off += partSize {
+			end := off + partSize
+			if end > size {
+				end = size
+			}
+			sum := md5.Sum(data[off:end])
+			sums = append(sums, sum[:]...)
+			n++
+		}
+		sum := md5.Sum(sums)
+		want := fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), n)
+		got, err := MultipartEtag(bytes.NewReader(data), partSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("size %d: MultipartEtag = %s, want %s", size, got, want)
+		}
+	}
+}
+
+func TestStreamPartSize(t *testing.T) {
+	a := &AwsS3cp{PartSize: 20 * 1024 * 1024}
+	var total int64
+	for num := int64(1); num <= maxParts; num++ {
+		size := a.streamPartSize(num)
+		if size > maxPartSize {
+			t.Fatalf("part %d: %d bytes", num, size)
+		}
+		total += size
+	}
+	if total < 5*1024*1024*1024*1024 {
+		t.Errorf("%d parts hold %d bytes, less than 5TiB", maxParts, total)
+	}
+	if a.streamPartSize(1) != a.PartSize || a.streamPartSize(1000) != a.PartSize {
+		t.Errorf("the first 1000 parts are not PartSize")
+	}
+}
+
+func TestResumeWithSSE(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		_, uploads := q["uploads"]
+		mu.Lock()
+		requests = append(requests, r.Method+" "+q.Get("uploadId"))
+		mu.Unlock()
+		switch {
+		case r.Method == "GET" && uploads:
+			fmt.Fprint(w, `<ListMultipartUploadsResult><IsTruncated>false</IsTruncated>`+
+				`<Upload><Key>data</Key><UploadId>old</UploadId></Upload></ListMultipartUploadsResult>`)
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "POST":
+			if got := r.Header.Get("X-Amz-Server-Side-Encryption"); got != SSEKMS {
+				t.Errorf("CreateMultipartUpload SSE = %q", got)
+			}
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>new</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == "GET":
+			fmt.Fprint(w, `<ListPartsResult><IsTruncated>false</IsTruncated></ListPartsResult>`)
+		case r.Method == "PUT":
+			w.Header().Set("ETag", `"etag"`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+	})
+
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(path, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	a := &AwsS3cp{Bucket: "bucket", S3Path: "data", FilePath: path, PartSize: 5, SSE: SSEKMS, Log: logger.NewLooger()}
+	a.SetS3client(client)
+	if _, err := a.ParallelPutAll(f, 5, 1); err != nil {
+		t.Fatal(err)
+	}
+	if id := a.UploadId; id == nil || *id != "new" {
+		t.Errorf("UploadId = %v", id)
+	}
+	want := []string{"GET ", "DELETE old", "POST ", "GET new", "PUT new", "PUT new"}
+	if fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+}
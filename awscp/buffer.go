@@ -0,0 +1,55 @@
+package awscp
+
+import (
+	"crypto/md5"
+	"io"
+)
+
+// partBuffers holds the part buffers shared by the uploads of all files, so
+// that the memory is bounded by their number however many files are
+// uploaded at once. Nil allocates a buffer for each part.
+var partBuffers chan *[]byte
+
+// SetPartBuffers limits the part buffers of all uploads to n.
+func SetPartBuffers(n int) {
+	partBuffers = make(chan *[]byte, n)
+	for i := 0; i < n; i++ {
+		partBuffers <- new([]byte)
+	}
+}
+
+// getBuffer waits for a free buffer and grows it to size. The buffer must
+// be given back by putBuffer, and not be held while waiting for another.
+func getBuffer(size int64) *[]byte {
+	b := new([]byte)
+	if partBuffers != nil {
+		b = <-partBuffers
+	}
+	if int64(cap(*b)) < size {
+		*b = make([]byte, size)
+	}
+	return b
+}
+
+func putBuffer(b *[]byte) {
+	if partBuffers != nil {
+		partBuffers <- b
+	}
+}
+
+// readPart reads size bytes of r into buf and returns them with their md5sum,
+// so that a part is read from the file only once to be hashed and uploaded.
+func readPart(r io.ReaderAt, size int64, buf []byte) (body []byte, sum []byte, err error) {
+	if int64(cap(buf)) < size {
+		buf = make([]byte, size)
+	}
+	body = buf[:size]
+	if n, err := r.ReadAt(body, 0); int64(n) < size {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	h := md5.Sum(body)
+	return body, h[:], nil
+}
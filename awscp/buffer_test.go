@@ -0,0 +1,101 @@
+package awscp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingReaderAt counts the bytes read from the "file".
+type countingReaderAt struct {
+	r     io.ReaderAt
+	bytes int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	atomic.AddInt64(&c.bytes, int64(n))
+	return n, err
+}
+
+const benchPartSize = 5 * 1024 * 1024
+
+// sendPart reads the body as UploadPart does: the signer hashes the
+// payload, then the body is sent.
+func sendPart(body io.ReadSeeker) {
+	io.Copy(sha256.New(), body)
+	body.Seek(0, io.SeekStart)
+	io.Copy(ioutil.Discard, body)
+}
+
+// BenchmarkPart compares the bytes read from the file per part: hashing
+// the section and then uploading it from the file, against reading it once
+// with readPart.
+func BenchmarkPart(b *testing.B) {
+	data := bytes.Repeat([]byte{'x'}, benchPartSize)
+
+	b.Run("section", func(b *testing.B) {
+		file := &countingReaderAt{r: bytes.NewReader(data)}
+		b.SetBytes(benchPartSize)
+		for i := 0; i < b.N; i++ {
+			section := io.NewSectionReader(file, 0, benchPartSize)
+			io.Copy(md5.New(), section)
+			section.Seek(0, io.SeekStart)
+			sendPart(section)
+		}
+		b.ReportMetric(float64(file.bytes)/float64(b.N), "read-B/op")
+	})
+
+	b.Run("buffer", func(b *testing.B) {
+		file := &countingReaderAt{r: bytes.NewReader(data)}
+		buf := make([]byte, benchPartSize)
+		b.SetBytes(benchPartSize)
+		for i := 0; i < b.N; i++ {
+			body, _, err := readPart(io.NewSectionReader(file, 0, benchPartSize), benchPartSize, buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			sendPart(bytes.NewReader(body))
+		}
+		b.ReportMetric(float64(file.bytes)/float64(b.N), "read-B/op")
+	})
+}
+
+func TestReadPart(t *testing.T) {
+	data := []byte("hello world")
+	body, sum, err := readPart(io.NewSectionReader(bytes.NewReader(data), 6, 5), 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := md5.Sum([]byte("world")); string(body) != "world" || !bytes.Equal(sum, want[:]) {
+		t.Errorf("readPart = %q, %x", body, sum)
+	}
+	if _, _, err := readPart(bytes.NewReader(data), 20, nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("short read err = %v", err)
+	}
+}
+
+func TestPartBuffers(t *testing.T) {
+	SetPartBuffers(1)
+	defer func() { partBuffers = nil }()
+	b := getBuffer(5)
+	if len(*b) != 5 {
+		t.Errorf("len = %d", len(*b))
+	}
+	got := make(chan *[]byte)
+	go func() { got <- getBuffer(3) }()
+	select {
+	case <-got:
+		t.Fatal("got a buffer over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	putBuffer(b)
+	if b2 := <-got; b2 != b {
+		t.Error("the buffer is not reused")
+	}
+}
@@ -0,0 +1,101 @@
+package awscp
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+
+	// The size and md5sum of the uncompressed (or unencrypted) file.
+	MetaOriginalSize = "s3cp-original-size"
+	MetaOriginalMD5  = "s3cp-original-md5"
+)
+
+var compressSuffix = map[string]string{
+	CompressGzip: ".gz",
+	CompressZstd: ".zst",
+}
+
+func (a *AwsS3cp) setCompressPath() {
+	if a.CompressSuffix {
+		a.S3Path += compressSuffix[a.Compress]
+	} else {
+		a.SetHeader("Content-Encoding", a.Compress)
+	}
+}
+
+// compareOriginal compares the file with the original size and md5sum
+// stored in metadata, as a compressed or encrypted object can not be compared.
+func (a *AwsS3cp) compareOriginal() error {
+	// The md5sum is always needed for the metadata of the upload.
+	if err := a.setOriginalMetadata(); err != nil {
+		return err
+	}
+	size := a.fileinfo.Size()
+	res, err := a.head()
+	if err != nil {
+		return err
+	}
+	if a.CheckSize {
+		s3size, _ := strconv.ParseInt(metaValue(res.Metadata, MetaOriginalSize), 10, 64)
+		if s3size != size {
+			return &S3FileSizeIsDifferentError{a.S3Path, s3size, size}
+		}
+	}
+	if a.CheckMD5 {
+		if s3md5 := metaValue(res.Metadata, MetaOriginalMD5); s3md5 != a.originalMd5 {
+			return &S3MD5sumIsDifferentError{a.S3Path, s3md5, a.originalMd5}
+		}
+	}
+	return a.compareStorageClass(res)
+}
+
+func (a *AwsS3cp) setOriginalMetadata() error {
+	var err error
+	if a.originalMd5, err = a.fileSum(0); err != nil {
+		return err
+	}
+	a.SetMetadata(MetaOriginalSize, strconv.FormatInt(a.fileinfo.Size(), 10))
+	a.SetMetadata(MetaOriginalMD5, a.originalMd5)
+	return nil
+}
+
+func (a *AwsS3cp) newEncoder(w io.Writer) (io.WriteCloser, error) {
+	switch a.Compress {
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unknown compression: %s", a.Compress)
+}
+
+// compressUpload compresses the file while uploading it with StreamUpload.
+func (a *AwsS3cp) compressUpload() error {
+	if _, err := a.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		enc, err := a.newEncoder(pw)
+		if err == nil {
+			_, err = io.Copy(enc, a.file)
+			if cerr := enc.Close(); err == nil {
+				err = cerr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	err := a.StreamUpload(pr)
+	// Unblock the encoder when the upload failed.
+	pr.CloseWithError(errors.New("upload canceled"))
+	return err
+}
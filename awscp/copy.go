@@ -0,0 +1,237 @@
+package awscp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// MaxCopyObjectSize is the largest object CopyObject can copy.
+	MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	maxParts          = 10000
+	maxPartSize       = 5 * 1024 * 1024 * 1024
+)
+
+// ParseS3URL splits "s3://bucket/key" into bucket and key.
+func ParseS3URL(s string) (bucket, key string, err error) {
+	if !IsS3URL(s) {
+		return "", "", errors.New("not a s3 url: " + s)
+	}
+	s = strings.TrimPrefix(s, "s3://")
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return s, "", nil
+	}
+	if i == 0 {
+		return "", "", errors.New("missing bucket: s3://" + s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+func IsS3URL(s string) bool {
+	return strings.HasPrefix(s, "s3://")
+}
+
+func (a *AwsS3cp) copySource() *string {
+	u := url.URL{Path: a.SrcBucket + "/" + a.SrcKey}
+	return aws.String(u.EscapedPath())
+}
+
+// S3Copy copies SrcBucket/SrcKey to Bucket/S3Path on the server side,
+// skipping it when the destination is the same as in FileUpload.
+func (a *AwsS3cp) S3Copy() (copied bool, err error) {
+	req := s3.HeadObjectInput{
+		Bucket: aws.String(a.SrcBucket),
+		Key:    aws.String(a.SrcKey),
+	}
+	a.setHeadObjectSSE(&req)
+	src, err := a.client.HeadObject(&req)
+	if err != nil {
+		return false, err
+	}
+	size := aws.Int64Value(src.ContentLength)
+	a.md5sum = metaValue(src.Metadata, MetaMD5)
+	if a.md5sum == "" && etagIsMD5(src) {
+		a.md5sum = strings.Trim(aws.StringValue(src.ETag), `"`)
+	}
+	checkSize, md5sum := size, ""
+	if !a.CheckSize {
+		checkSize = 0
+	}
+	if a.CheckMD5 {
+		md5sum = a.md5sum
+	}
+	if err = a.Exists(checkSize, md5sum); err == nil {
+		if a.Retag {
+			a.Retagged, err = a.UpdateTags()
+		}
+		return false, err
+	}
+	if !a.ReplaceMetadata {
+		a.setSourceHeaders(src)
+	}
+	if a.StorageClass == "" {
+		// CopyObject and CreateMultipartUpload default to STANDARD.
+		a.StorageClass = aws.StringValue(src.StorageClass)
+	}
+	if size > MaxCopyObjectSize {
+		err = a.multipartCopy(size)
+	} else {
+		err = a.copyObject()
+	}
+	return err == nil, err
+}
+
+// setSourceHeaders preserves the headers and metadata of the source object.
+// They are sent explicitly because UploadPartCopy does not copy them.
+func (a *AwsS3cp) setSourceHeaders(src *s3.HeadObjectOutput) {
+	a.MimeType = aws.StringValue(src.ContentType)
+	a.Headers = map[string]string{}
+	for name, v := range map[string]*string{
+		"Cache-Control":       src.CacheControl,
+		"Content-Encoding":    src.ContentEncoding,
+		"Content-Disposition": src.ContentDisposition,
+		"Content-Language":    src.ContentLanguage,
+		"Expires":             src.Expires,
+	} {
+		if v != nil {
+			a.Headers[name] = *v
+		}
+	}
+	a.Metadata = map[string]string{}
+	for k, v := range src.Metadata {
+		if !strings.EqualFold(k, MetaMD5) {
+			a.Metadata[strings.ToLower(k)] = aws.StringValue(v)
+		}
+	}
+}
+
+func (a *AwsS3cp) copyObject() error {
+	req := s3.CopyObjectInput{
+		Bucket:            aws.String(a.Bucket),
+		Key:               aws.String(a.S3Path),
+		CopySource:        a.copySource(),
+		ACL:               aws.String(a.Acl),
+		ContentType:       aws.String(a.MimeType),
+		Metadata:          a.metadata(),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	}
+	if a.StorageClass != "" {
+		req.StorageClass = aws.String(a.StorageClass)
+	}
+	if len(a.Tags) > 0 {
+		req.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+		req.Tagging = a.tagging()
+	}
+	req.CopySourceSSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.CopySourceSSECustomerKey = a.sseCustomerKey()
+	a.setCopyObjectHeaders(&req)
+	a.setCopyObjectSSE(&req)
+	_, err := a.client.CopyObject(&req)
+	if err != nil {
+		a.Log.Warning("CopyObject err:%v", err)
+	}
+	return err
+}
+
+// copyPartSize keeps the number of parts within the S3 limit.
+func (a *AwsS3cp) copyPartSize(size int64) int64 {
+	partSize := a.PartSize
+	if min := (size + maxParts - 1) / maxParts; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+func (a *AwsS3cp) multipartCopy(size int64) error {
+	if len(a.Tags) == 0 {
+		// UploadPartCopy does not copy the tags as CopyObject does.
+		if err := a.setSourceTags(); err != nil {
+			return err
+		}
+	}
+	if err := a.createMultipartUpload(); err != nil {
+		return err
+	}
+	partSize := a.copyPartSize(size)
+	queue := make(chan int64)
+	results := make(chan result)
+	done := make(chan struct{})
+	defer close(done)
+
+	for i := 0; i < a.workNum(); i++ {
+		go func() {
+			for n := range queue {
+				res := result{}
+				res.part, res.err = a.uploadPartCopy(n, partSize, size)
+				select {
+				case results <- res:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	count := int((size + partSize - 1) / partSize)
+	go func() {
+		defer close(queue)
+		for n := int64(1); n <= int64(count); n++ {
+			select {
+			case queue <- n:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	parts := make([]s3.CompletedPart, 0, count)
+	var err error
+	for i := 0; i < count; i++ {
+		res := <-results
+		if res.err != nil {
+			err = fmt.Errorf("%v [part:%d err:%v]", err, aws.Int64Value(res.part.PartNumber), res.err)
+		} else {
+			parts = append(parts, res.part)
+		}
+	}
+	if err == nil {
+		err = a.completeMultipartUpload(parts)
+	}
+	if err != nil {
+		// The copied parts are billed until the upload is aborted.
+		a.abortMultipartUpload()
+	}
+	return err
+}
+
+func (a *AwsS3cp) uploadPartCopy(n, partSize, size int64) (s3.CompletedPart, error) {
+	first := (n - 1) * partSize
+	last := first + partSize - 1
+	if last >= size {
+		last = size - 1
+	}
+	a.Log.Info("Start copy Part Num:%d", n)
+	req := s3.UploadPartCopyInput{
+		Bucket:          aws.String(a.Bucket),
+		Key:             aws.String(a.S3Path),
+		CopySource:      a.copySource(),
+		CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", first, last)),
+		PartNumber:      aws.Int64(n),
+		UploadId:        a.UploadId,
+	}
+	req.SSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.SSECustomerKey = a.sseCustomerKey()
+	req.CopySourceSSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.CopySourceSSECustomerKey = a.sseCustomerKey()
+	resp, err := a.client.UploadPartCopy(&req)
+	if err != nil {
+		a.Log.Warning("UploadPartCopy err Part Num:%d err: %v", n, err)
+		return s3.CompletedPart{PartNumber: aws.Int64(n)}, err
+	}
+	return s3.CompletedPart{ETag: resp.CopyPartResult.ETag, PartNumber: aws.Int64(n)}, nil
+}
@@ -0,0 +1,287 @@
+package awscp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Client-side envelope encryption: the object is encrypted with a random
+// data key by AES-256-GCM in chunks of CSEChunkSize, and the data key is
+// stored in metadata wrapped by the key of -encrypt-key-file. Chunk i uses
+// the base nonce xor i, and the last chunk is authenticated as such, so
+// chunks can be neither reordered nor truncated.
+const (
+	CSEAlgorithm = "AES-256-GCM"
+	CSEChunkSize = 64 * 1024
+
+	MetaCSEAlgorithm = "s3cp-cse-alg"
+	MetaCSEKey       = "s3cp-cse-key"
+	MetaCSENonce     = "s3cp-cse-nonce"
+	MetaCSEChunk     = "s3cp-cse-chunk"
+)
+
+// ReadEncryptKey reads a 256 bit key from file.
+func ReadEncryptKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes: " + path)
+	}
+	return b, nil
+}
+
+type cse struct {
+	aead  cipher.AEAD
+	nonce []byte
+	chunk int64
+	size  int64 // plaintext size
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newCSE creates the parameters for a new object with a random data key,
+// and the metadata to store them.
+func newCSE(masterKey []byte, size int64) (*cse, map[string]string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped := make([]byte, master.NonceSize())
+	if _, err := rand.Read(wrapped); err != nil {
+		return nil, nil, err
+	}
+	wrapped = master.Seal(wrapped, wrapped, dataKey, nil)
+
+	c := &cse{chunk: CSEChunkSize, size: size}
+	if c.aead, err = newAEAD(dataKey); err != nil {
+		return nil, nil, err
+	}
+	c.nonce = make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(c.nonce); err != nil {
+		return nil, nil, err
+	}
+	meta := map[string]string{
+		MetaCSEAlgorithm: CSEAlgorithm,
+		MetaCSEKey:       base64.StdEncoding.EncodeToString(wrapped),
+		MetaCSENonce:     base64.StdEncoding.EncodeToString(c.nonce),
+		MetaCSEChunk:     strconv.FormatInt(c.chunk, 10),
+	}
+	return c, meta, nil
+}
+
+// openCSE unwraps the data key stored in the metadata.
+func openCSE(masterKey []byte, meta map[string]*string) (*cse, error) {
+	if alg := metaValue(meta, MetaCSEAlgorithm); alg != CSEAlgorithm {
+		return nil, errors.New("unknown client-side encryption: " + alg)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metaValue(meta, MetaCSEKey))
+	if err != nil {
+		return nil, err
+	}
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < master.NonceSize() {
+		return nil, errors.New("invalid " + MetaCSEKey)
+	}
+	dataKey, err := master.Open(nil, wrapped[:master.NonceSize()], wrapped[master.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("can not unwrap the data key: wrong encryption key")
+	}
+	c := &cse{}
+	if c.aead, err = newAEAD(dataKey); err != nil {
+		return nil, err
+	}
+	if c.nonce, err = base64.StdEncoding.DecodeString(metaValue(meta, MetaCSENonce)); err != nil {
+		return nil, err
+	}
+	if len(c.nonce) != c.aead.NonceSize() {
+		return nil, errors.New("invalid " + MetaCSENonce)
+	}
+	if c.chunk, err = strconv.ParseInt(metaValue(meta, MetaCSEChunk), 10, 64); err != nil || c.chunk <= 0 {
+		return nil, errors.New("invalid " + MetaCSEChunk)
+	}
+	if c.size, err = strconv.ParseInt(metaValue(meta, MetaOriginalSize), 10, 64); err != nil {
+		return nil, errors.New("invalid " + MetaOriginalSize)
+	}
+	return c, nil
+}
+
+// chunks returns the number of chunks; an empty object has one empty chunk.
+func (c *cse) chunks() int64 {
+	n := (c.size + c.chunk - 1) / c.chunk
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+func (c *cse) cipherChunk() int64 {
+	return c.chunk + int64(c.aead.Overhead())
+}
+
+func (c *cse) cipherSize() int64 {
+	return c.size + c.chunks()*int64(c.aead.Overhead())
+}
+
+func (c *cse) chunkNonce(i int64) []byte {
+	nonce := make([]byte, len(c.nonce))
+	copy(nonce, c.nonce)
+	ctr := make([]byte, 8)
+	binary.BigEndian.PutUint64(ctr, uint64(i))
+	for j := range ctr {
+		nonce[len(nonce)-8+j] ^= ctr[j]
+	}
+	return nonce
+}
+
+func (c *cse) aad(i int64) []byte {
+	if i == c.chunks()-1 {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encrypt encrypts the chunks [first, last) of r into buf, reading each
+// chunk into plain.
+func (c *cse) encrypt(r io.ReaderAt, first, last int64, buf, plain []byte) ([]byte, error) {
+	buf = buf[:0]
+	if int64(len(plain)) < c.chunk {
+		plain = make([]byte, c.chunk)
+	}
+	for i := first; i < last; i++ {
+		off := i * c.chunk
+		n := c.chunk
+		if off+n > c.size {
+			n = c.size - off
+		}
+		if m, err := r.ReadAt(plain[:n], off); int64(m) < n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		buf = c.aead.Seal(buf, c.chunkNonce(i), plain[:n], c.aad(i))
+	}
+	return buf, nil
+}
+
+// decrypt decrypts in place the ciphertext buf starting at chunk first.
+func (c *cse) decrypt(buf []byte, first int64) ([]byte, error) {
+	out := 0
+	step := int(c.cipherChunk())
+	for in, i := 0, first; in < len(buf); in, i = in+step, i+1 {
+		end := in + step
+		if end > len(buf) {
+			end = len(buf)
+		}
+		plain, err := c.aead.Open(buf[in:in], c.chunkNonce(i), buf[in:end], c.aad(i))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt chunk %d: %v", i, err)
+		}
+		out += copy(buf[out:], plain)
+	}
+	return buf[:out], nil
+}
+
+// encryptUpload encrypts the file and uploads it; the parts are encrypted
+// and uploaded in parallel, each part holding whole chunks.
+func (a *AwsS3cp) encryptUpload() error {
+	c, meta, err := newCSE(a.EncryptKey, a.fileinfo.Size())
+	if err != nil {
+		return err
+	}
+	for k, v := range meta {
+		a.SetMetadata(k, v)
+	}
+	perPart := a.PartSize / c.chunk
+	if perPart < 1 {
+		perPart = 1
+	}
+	if c.chunks() <= perPart {
+		b := getBuffer(c.cipherSize())
+		defer putBuffer(b)
+		buf, err := c.encrypt(a.file, 0, c.chunks(), *b, nil)
+		if err != nil {
+			return err
+		}
+		return a.putObject(bytes.NewReader(buf), int64(len(buf)))
+	}
+
+	// A new data key is used for every upload, so old parts can not be reused.
+	if err = a.createMultipartUpload(); err != nil {
+		return err
+	}
+	count := (c.chunks() + perPart - 1) / perPart
+	queue := make(chan int64)
+	results := make(chan result)
+	go func() {
+		defer close(queue)
+		for n := int64(1); n <= count; n++ {
+			queue <- n
+		}
+	}()
+	for i := 0; i < a.workNum(); i++ {
+		go func() {
+			plain := make([]byte, c.chunk)
+			for n := range queue {
+				res := result{}
+				last := n * perPart
+				if last > c.chunks() {
+					last = c.chunks()
+				}
+				b := getBuffer(perPart * c.cipherChunk())
+				buf, err := c.encrypt(a.file, (n-1)*perPart, last, *b, plain)
+				if res.err = err; err == nil {
+					res.part, res.err = a.uploadPartBytes(buf, n)
+				}
+				putBuffer(b)
+				if res.err != nil {
+					res.part.PartNumber = aws.Int64(n)
+				}
+				results <- res
+			}
+		}()
+	}
+	parts := make([]s3.CompletedPart, 0, count)
+	for i := int64(0); i < count; i++ {
+		res := <-results
+		if res.err != nil {
+			err = fmt.Errorf("%v [part:%d err:%v]", err, aws.Int64Value(res.part.PartNumber), res.err)
+		} else {
+			parts = append(parts, res.part)
+		}
+	}
+	if err == nil {
+		err = a.completeMultipartUpload(parts)
+	}
+	if err != nil {
+		a.abortMultipartUpload()
+	}
+	return err
+}
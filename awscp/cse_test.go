@@ -0,0 +1,61 @@
+package awscp
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestCSE(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	for _, size := range []int64{0, 1, CSEChunkSize, CSEChunkSize*3 + 5} {
+		plain := make([]byte, size)
+		for i := range plain {
+			plain[i] = byte(i)
+		}
+		c, meta, err := newCSE(key, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Encrypt in two parts as a multipart upload does.
+		half := c.chunks() / 2
+		p1, err := c.encrypt(bytes.NewReader(plain), 0, half, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p2, err := c.encrypt(bytes.NewReader(plain), half, c.chunks(), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := int64(len(p1) + len(p2)); got != c.cipherSize() {
+			t.Errorf("size %d: cipher size = %d, want %d", size, got, c.cipherSize())
+		}
+
+		m := map[string]*string{MetaOriginalSize: aws.String(strconv.FormatInt(size, 10))}
+		for k, v := range meta {
+			m[k] = aws.String(v)
+		}
+		d, err := openCSE(key, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.decrypt(append(p1, p2...), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted data is different", size)
+		}
+		if half > 0 {
+			// Chunks out of place must not decrypt.
+			if _, err := d.decrypt(p2, 0); err == nil {
+				t.Errorf("size %d: reordered chunks decrypted", size)
+			}
+		}
+		if _, err := openCSE(bytes.Repeat([]byte{2}, 32), m); err == nil {
+			t.Errorf("size %d: wrong key accepted", size)
+		}
+	}
+}
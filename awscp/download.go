@@ -0,0 +1,274 @@
+package awscp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type rangeWork struct {
+	n   int64
+	buf []byte
+}
+
+type rangeResult struct {
+	n   int64
+	buf []byte
+	sum []byte
+	err error
+}
+
+// Download writes the object Bucket/S3Path to w. Ranges are fetched by
+// WorkNum workers in parallel and written strictly in order; at most
+// WorkNum ranges are held in memory. The content is verified against the
+// ETag (or the md5 stored in metadata) at the end. Client-side encrypted
+// objects are decrypted with EncryptKey.
+func (a *AwsS3cp) Download(w io.Writer) error {
+	_, err := a.download(w)
+	return err
+}
+
+// DownloadFile downloads the object to path through a temporary file in
+// the same directory, and restores the mtime, mode and owner stored at
+// upload. A preserved symlink is restored as a symlink.
+func (a *AwsS3cp) DownloadFile(path string) error {
+	f, err := createTemp(filepath.Dir(path), "."+filepath.Base(path)+".s3cp")
+	if err != nil {
+		return err
+	}
+	head, err := a.download(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	symlink := ""
+	if err == nil {
+		if symlink = metaValue(head.Metadata, MetaSymlink); symlink != "" {
+			// Put the symlink at the temporary name to replace path atomically.
+			if err = os.Remove(f.Name()); err == nil {
+				err = os.Symlink(symlink, f.Name())
+			}
+		}
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if symlink != "" {
+		return nil
+	}
+	return a.restoreFileMetadata(path, head.Metadata)
+}
+
+// createTemp creates a new file in dir as ioutil.TempFile, but with the
+// mode 0666 of os.Create, so that the umask applies when no mode is stored.
+func createTemp(dir, prefix string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+func (a *AwsS3cp) download(w io.Writer) (*s3.HeadObjectOutput, error) {
+	req := s3.HeadObjectInput{
+		Bucket: aws.String(a.Bucket),
+		Key:    aws.String(a.S3Path),
+	}
+	a.setHeadObjectSSE(&req)
+	head, err := a.client.HeadObject(&req)
+	if err != nil {
+		return nil, err
+	}
+	size := aws.Int64Value(head.ContentLength)
+	etag := aws.StringValue(head.ETag)
+	expect := metaValue(head.Metadata, MetaMD5)
+	partSize := a.PartSize
+	if etagIsMD5(head) {
+		expect = strings.Trim(etag, `"`)
+		if strings.Contains(expect, "-") {
+			// Fetch the same parts as the upload to rebuild the multipart etag.
+			if partSize, err = a.firstPartSize(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var c *cse
+	if metaValue(head.Metadata, MetaCSEKey) != "" {
+		if a.EncryptKey == nil {
+			return nil, errors.New(a.S3Path + " is client-side encrypted: the encryption key is required")
+		}
+		if c, err = openCSE(a.EncryptKey, head.Metadata); err != nil {
+			return nil, err
+		}
+		if c.cipherSize() != size {
+			return nil, &S3FileSizeIsDifferentError{a.S3Path, size, c.cipherSize()}
+		}
+		// GCM authenticates each chunk; verify the plaintext md5 in addition.
+		expect = metaValue(head.Metadata, MetaOriginalMD5)
+		partSize = a.PartSize / c.chunk * c.cipherChunk()
+		if partSize < c.cipherChunk() {
+			partSize = c.cipherChunk()
+		}
+	}
+	if expect == "" {
+		a.Log.Warning("%s: no checksum to verify", a.S3Path)
+	}
+	if partSize <= 0 {
+		partSize = size
+	}
+
+	count := int64(0)
+	if size > 0 {
+		count = (size + partSize - 1) / partSize
+	}
+	done := make(chan struct{})
+	defer close(done)
+	pool := make(chan []byte, a.workNum())
+	for i := 0; i < a.workNum(); i++ {
+		pool <- nil
+	}
+	queue := make(chan rangeWork)
+	results := make(chan rangeResult)
+	go func() {
+		defer close(queue)
+		for n := int64(0); n < count; n++ {
+			var buf []byte
+			select {
+			case buf = <-pool:
+			case <-done:
+				return
+			}
+			select {
+			case queue <- rangeWork{n, buf}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for i := 0; i < a.workNum(); i++ {
+		go func() {
+			for rw := range queue {
+				res := a.getRange(rw, partSize, size, etag)
+				if res.err == nil && c != nil {
+					res.buf, res.err = c.decrypt(res.buf, rw.n*partSize/c.cipherChunk())
+				}
+				select {
+				case results <- res:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	whole := md5.New()
+	sums := &bytes.Buffer{}
+	pending := map[int64]rangeResult{}
+	for next := int64(0); next < count; {
+		res := <-results
+		if res.err != nil {
+			return nil, res.err
+		}
+		pending[res.n] = res
+		for {
+			res, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if _, err := w.Write(res.buf); err != nil {
+				return nil, err
+			}
+			whole.Write(res.buf)
+			sums.Write(res.sum)
+			pool <- res.buf[:cap(res.buf)]
+			next++
+		}
+	}
+	return head, a.verify(expect, whole, sums, count)
+}
+
+func (a *AwsS3cp) verify(expect string, whole hash.Hash, sums *bytes.Buffer, count int64) error {
+	if expect == "" {
+		return nil
+	}
+	got := hex.EncodeToString(whole.Sum(nil))
+	if i := strings.LastIndex(expect, "-"); i >= 0 {
+		if expect[i+1:] != fmt.Sprint(count) {
+			a.Log.Warning("%s: parts are not the same size, can not verify %s", a.S3Path, expect)
+			return nil
+		}
+		h := md5.Sum(sums.Bytes())
+		got = fmt.Sprintf("%s-%d", hex.EncodeToString(h[:]), count)
+	}
+	if got != expect {
+		return &S3MD5sumIsDifferentError{a.S3Path, expect, got}
+	}
+	return nil
+}
+
+func (a *AwsS3cp) firstPartSize() (int64, error) {
+	req := s3.HeadObjectInput{
+		Bucket:     aws.String(a.Bucket),
+		Key:        aws.String(a.S3Path),
+		PartNumber: aws.Int64(1),
+	}
+	a.setHeadObjectSSE(&req)
+	res, err := a.client.HeadObject(&req)
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(res.ContentLength), nil
+}
+
+func (a *AwsS3cp) getRange(rw rangeWork, partSize, size int64, etag string) rangeResult {
+	res := rangeResult{n: rw.n}
+	first := rw.n * partSize
+	length := partSize
+	if first+length > size {
+		length = size - first
+	}
+	if int64(cap(rw.buf)) < length {
+		rw.buf = make([]byte, partSize)
+	}
+	res.buf = rw.buf[:length]
+	req := s3.GetObjectInput{
+		Bucket:  aws.String(a.Bucket),
+		Key:     aws.String(a.S3Path),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", first, first+length-1)),
+		IfMatch: aws.String(etag),
+	}
+	a.setGetObjectSSE(&req)
+	a.Log.Debug("GetObject %s range:%s", a.S3Path, *req.Range)
+	resp, err := a.client.GetObject(&req)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer resp.Body.Close()
+	if _, err = io.ReadFull(resp.Body, res.buf); err != nil {
+		res.err = err
+		return res
+	}
+	sum := md5.Sum(res.buf)
+	res.sum = sum[:]
+	return res
+}
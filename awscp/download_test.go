@@ -0,0 +1,66 @@
+package awscp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/masahide/s3cp/logger"
+)
+
+// newTestClient returns an S3 client of a fake S3 served by h.
+func newTestClient(t *testing.T, h http.HandlerFunc) *s3.S3 {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return s3.New(sess)
+}
+
+func TestDownloadSymlink(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.Header().Set("X-Amz-Meta-"+MetaSymlink, "target")
+	})
+
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "link")
+	// An existing file is replaced by the link.
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AwsS3cp{Bucket: "bucket", S3Path: "link", PartSize: 5, Log: logger.NewLooger()}
+	a.SetS3client(client)
+	if err := a.DownloadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(path); err != nil || target != "target" {
+		t.Errorf("link = %q, %v", target, err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temporary files are left: %d files", len(files))
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package awscp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/masahide/s3cp/logger"
+)
+
+func TestDownloadMode(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("ETag", `"5d41402abc4b2a76b9719d911017c592"`)
+		if r.Method == "GET" {
+			w.Write([]byte("hello"))
+		}
+	})
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer syscall.Umask(syscall.Umask(027))
+	created, err := os.Create(filepath.Join(dir, "created"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	want, _ := os.Stat(created.Name())
+
+	// Without a stored mode the umask applies as with os.Create.
+	path := filepath.Join(dir, "data")
+	a := &AwsS3cp{Bucket: "bucket", S3Path: "data", PartSize: 5, Log: logger.NewLooger()}
+	a.SetS3client(client)
+	if err := a.DownloadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != want.Mode() {
+		t.Errorf("mode = %v, want %v", fi.Mode(), want.Mode())
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "hello" {
+		t.Errorf("content = %q", b)
+	}
+}
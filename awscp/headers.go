@@ -0,0 +1,129 @@
+package awscp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const metaPrefix = "X-Amz-Meta-"
+
+// ParseHeader parses "Name: value".
+func ParseHeader(s string) (name, value string, err error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return "", "", errors.New("invalid header: " + s)
+	}
+	name = http.CanonicalHeaderKey(strings.TrimSpace(s[:i]))
+	value = strings.TrimSpace(s[i+1:])
+	switch {
+	case name == "Cache-Control", name == "Content-Type", name == "Content-Encoding",
+		name == "Content-Disposition", name == "Content-Language":
+	case name == "Expires":
+		if _, err := parseExpires(value); err != nil {
+			return "", "", errors.New("invalid Expires: " + value)
+		}
+	case strings.HasPrefix(name, metaPrefix) && len(name) > len(metaPrefix):
+	default:
+		return "", "", errors.New("unsupported header: " + name)
+	}
+	return name, value, nil
+}
+
+// ParseMetadata parses "key=value".
+func ParseMetadata(s string) (key, value string, err error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return "", "", errors.New("invalid metadata: " + s)
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), nil
+}
+
+func parseExpires(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return http.ParseTime(value)
+}
+
+// SetHeader sets a header parsed by ParseHeader. x-amz-meta-* goes to Metadata.
+func (a *AwsS3cp) SetHeader(name, value string) {
+	if strings.HasPrefix(name, metaPrefix) {
+		a.SetMetadata(strings.ToLower(name[len(metaPrefix):]), value)
+		return
+	}
+	if name == "Content-Type" {
+		a.MimeType = value
+		return
+	}
+	if a.Headers == nil {
+		a.Headers = map[string]string{}
+	}
+	a.Headers[name] = value
+}
+
+func (a *AwsS3cp) SetMetadata(key, value string) {
+	if a.Metadata == nil {
+		a.Metadata = map[string]string{}
+	}
+	a.Metadata[key] = value
+}
+
+func (a *AwsS3cp) metadata() map[string]*string {
+	m := map[string]*string{}
+	for k, v := range a.Metadata {
+		m[k] = aws.String(v)
+	}
+	if a.md5sum != "" {
+		m[MetaMD5] = aws.String(a.md5sum)
+	}
+	return m
+}
+
+func (a *AwsS3cp) header(name string) *string {
+	if v, ok := a.Headers[name]; ok {
+		return aws.String(v)
+	}
+	return nil
+}
+
+func (a *AwsS3cp) expires() *time.Time {
+	v, ok := a.Headers["Expires"]
+	if !ok {
+		return nil
+	}
+	t, err := parseExpires(v)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func (a *AwsS3cp) setPutObjectHeaders(req *s3.PutObjectInput) {
+	req.CacheControl = a.header("Cache-Control")
+	req.ContentEncoding = a.header("Content-Encoding")
+	req.ContentDisposition = a.header("Content-Disposition")
+	req.ContentLanguage = a.header("Content-Language")
+	req.Expires = a.expires()
+}
+
+func (a *AwsS3cp) setCreateMultipartUploadHeaders(req *s3.CreateMultipartUploadInput) {
+	req.ContentType = aws.String(a.MimeType)
+	req.CacheControl = a.header("Cache-Control")
+	req.ContentEncoding = a.header("Content-Encoding")
+	req.ContentDisposition = a.header("Content-Disposition")
+	req.ContentLanguage = a.header("Content-Language")
+	req.Expires = a.expires()
+}
+
+func (a *AwsS3cp) setCopyObjectHeaders(req *s3.CopyObjectInput) {
+	req.CacheControl = a.header("Cache-Control")
+	req.ContentEncoding = a.header("Content-Encoding")
+	req.ContentDisposition = a.header("Content-Disposition")
+	req.ContentLanguage = a.header("Content-Language")
+	req.Expires = a.expires()
+}
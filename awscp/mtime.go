@@ -0,0 +1,102 @@
+package awscp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Values of Compare.
+const (
+	CompareSize  = "size"
+	CompareMtime = "mtime"
+)
+
+// Metadata of the source file. The mtime is in unix seconds and the mode
+// is octal.
+const (
+	MetaMtime = "s3cp-mtime"
+	MetaMode  = "s3cp-mode"
+	MetaUID   = "s3cp-uid"
+	MetaGID   = "s3cp-gid"
+)
+
+type S3MtimeIsNewerError struct {
+	S3Path  string
+	S3Mtime string
+	Mtime   int64
+}
+
+func (e *S3MtimeIsNewerError) Error() string {
+	return fmt.Sprintf("%s mtime is %q < %d", e.S3Path, e.S3Mtime, e.Mtime)
+}
+
+func (a *AwsS3cp) setFileMetadata() {
+	a.SetMetadata(MetaMtime, strconv.FormatInt(a.fileinfo.ModTime().Unix(), 10))
+	if !a.PreserveMode {
+		return
+	}
+	a.SetMetadata(MetaMode, strconv.FormatUint(uint64(a.fileinfo.Mode().Perm()), 8))
+	if uid, gid, ok := fileOwner(a.fileinfo); ok {
+		a.SetMetadata(MetaUID, uid)
+		a.SetMetadata(MetaGID, gid)
+	}
+}
+
+// compareMtime compares the size and the stored mtime without reading the
+// file; the file is uploaded when its mtime is newer, or unknown.
+func (a *AwsS3cp) compareMtime() error {
+	res, err := a.head()
+	if err == nil {
+		size := a.fileinfo.Size()
+		s3size := aws.Int64Value(res.ContentLength)
+		if a.Compress != "" || a.EncryptKey != nil {
+			s3size, _ = strconv.ParseInt(metaValue(res.Metadata, MetaOriginalSize), 10, 64)
+		}
+		if s3size != size {
+			err = &S3FileSizeIsDifferentError{a.S3Path, s3size, size}
+		} else if s3mtime := metaValue(res.Metadata, MetaMtime); !mtimeIsSame(s3mtime, a.fileinfo.ModTime()) {
+			err = &S3MtimeIsNewerError{a.S3Path, s3mtime, a.fileinfo.ModTime().Unix()}
+		} else {
+			err = a.compareStorageClass(res)
+		}
+	}
+	if err != nil && (a.Compress != "" || a.EncryptKey != nil) {
+		// The upload needs the original size and md5sum in metadata.
+		if e := a.setOriginalMetadata(); e != nil {
+			return e
+		}
+	}
+	return err
+}
+
+// mtimeIsSame reports whether the object is not older than mtime.
+func mtimeIsSame(s3mtime string, mtime time.Time) bool {
+	t, err := strconv.ParseInt(s3mtime, 10, 64)
+	return err == nil && mtime.Unix() <= t
+}
+
+// restoreFileMetadata sets the mtime, mode and owner stored at upload to
+// the downloaded file. A failure to change the owner is only a warning, as
+// it needs root.
+func (a *AwsS3cp) restoreFileMetadata(path string, meta map[string]*string) error {
+	if m, err := strconv.ParseUint(metaValue(meta, MetaMode), 8, 32); err == nil {
+		if err := os.Chmod(path, os.FileMode(m).Perm()); err != nil {
+			return err
+		}
+	}
+	uid, err1 := strconv.Atoi(metaValue(meta, MetaUID))
+	gid, err2 := strconv.Atoi(metaValue(meta, MetaGID))
+	if err1 == nil && err2 == nil {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			a.Log.Warning("%s: %v", path, err)
+		}
+	}
+	if t, err := strconv.ParseInt(metaValue(meta, MetaMtime), 10, 64); err == nil {
+		return os.Chtimes(path, time.Now(), time.Unix(t, 0))
+	}
+	return nil
+}
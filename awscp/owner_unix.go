@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package awscp
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+func fileOwner(fi os.FileInfo) (uid, gid string, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatUint(uint64(st.Uid), 10), strconv.FormatUint(uint64(st.Gid), 10), true
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package awscp
+
+import "os"
+
+func fileOwner(fi os.FileInfo) (uid, gid string, ok bool) {
+	return "", "", false
+}
@@ -0,0 +1,59 @@
+package awscp
+
+import (
+	"os"
+)
+
+// Actions reported by Plan.
+const (
+	PlanNew                   = "new"
+	PlanSizeDifferent         = "size-different"
+	PlanMD5Different          = "md5-different"
+	PlanStorageClassDifferent = "storage-class-different"
+	PlanMtimeNewer            = "mtime-newer"
+	PlanSymlinkDifferent      = "symlink-different"
+	PlanSame                  = "same"
+)
+
+// Plan runs the comparison of FileUpload and returns what it would do with
+// the file. It only reads the bucket.
+func (a *AwsS3cp) Plan() (action string, err error) {
+	if a.SymlinkTarget != "" {
+		return planAction(a.compareSymlink())
+	}
+	a.file, err = os.Open(a.FilePath)
+	if err != nil {
+		return "", err
+	}
+	defer a.file.Close()
+	a.fileinfo, err = a.file.Stat()
+	if err != nil {
+		return "", err
+	}
+	a.setFileMetadata()
+	if a.Compress != "" {
+		a.setCompressPath()
+	}
+	return planAction(a.CompareFile())
+}
+
+func planAction(err error) (string, error) {
+	switch err := err.(type) {
+	case nil:
+		return PlanSame, nil
+	case *S3NotExistsError:
+		return PlanNew, nil
+	case *S3FileSizeIsDifferentError:
+		return PlanSizeDifferent, nil
+	case *S3MD5sumIsDifferentError:
+		return PlanMD5Different, nil
+	case *S3StorageClassIsDifferentError:
+		return PlanStorageClassDifferent, nil
+	case *S3MtimeIsNewerError:
+		return PlanMtimeNewer, nil
+	case *S3SymlinkIsDifferentError:
+		return PlanSymlinkDifferent, nil
+	default:
+		return "", err
+	}
+}
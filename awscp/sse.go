@@ -0,0 +1,138 @@
+package awscp
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+
+	// MetaMD5 holds the md5sum (or multipart etag) computed by CompareFile,
+	// used when the ETag is not an MD5 of the content.
+	MetaMD5 = "s3cp-md5"
+)
+
+// ReadSSECustomerKey reads a 256 bit SSE-C key from file.
+func ReadSSECustomerKey(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(b) != 32 {
+		return "", errors.New("SSE-C key must be 32 bytes: " + path)
+	}
+	return string(b), nil
+}
+
+// ValidateSSE checks the combination of encryption options.
+func ValidateSSE(sse, kmsKeyId, customerKey string) error {
+	switch sse {
+	case "", SSEAES256, SSEKMS:
+	default:
+		return errors.New("-sse must be " + SSEAES256 + " or " + SSEKMS)
+	}
+	if kmsKeyId != "" && sse != SSEKMS {
+		return errors.New("-sse-kms-key-id requires -sse " + SSEKMS)
+	}
+	if customerKey != "" && sse != "" {
+		return errors.New("SSE-C can not be used with -sse")
+	}
+	return nil
+}
+
+func (a *AwsS3cp) sseCustomerAlgorithm() *string {
+	if a.SSECustomerKey == "" {
+		return nil
+	}
+	return aws.String(SSEAES256)
+}
+
+func (a *AwsS3cp) sseCustomerKey() *string {
+	if a.SSECustomerKey == "" {
+		return nil
+	}
+	return aws.String(a.SSECustomerKey)
+}
+
+func (a *AwsS3cp) sse() *string {
+	if a.SSE == "" {
+		return nil
+	}
+	return aws.String(a.SSE)
+}
+
+func (a *AwsS3cp) sseKMSKeyId() *string {
+	if a.SSEKMSKeyId == "" {
+		return nil
+	}
+	return aws.String(a.SSEKMSKeyId)
+}
+
+// The SDK fills SSECustomerKeyMD5 from SSECustomerKey.
+
+func (a *AwsS3cp) setPutObjectSSE(req *s3.PutObjectInput) {
+	req.ServerSideEncryption = a.sse()
+	req.SSEKMSKeyId = a.sseKMSKeyId()
+	req.SSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.SSECustomerKey = a.sseCustomerKey()
+}
+
+func (a *AwsS3cp) setCreateMultipartUploadSSE(req *s3.CreateMultipartUploadInput) {
+	req.ServerSideEncryption = a.sse()
+	req.SSEKMSKeyId = a.sseKMSKeyId()
+	req.SSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.SSECustomerKey = a.sseCustomerKey()
+}
+
+func (a *AwsS3cp) setUploadPartSSE(req *s3.UploadPartInput) {
+	req.SSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.SSECustomerKey = a.sseCustomerKey()
+}
+
+func (a *AwsS3cp) setHeadObjectSSE(req *s3.HeadObjectInput) {
+	req.SSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.SSECustomerKey = a.sseCustomerKey()
+}
+
+func (a *AwsS3cp) setGetObjectSSE(req *s3.GetObjectInput) {
+	req.SSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.SSECustomerKey = a.sseCustomerKey()
+}
+
+func (a *AwsS3cp) setCopyObjectSSE(req *s3.CopyObjectInput) {
+	req.ServerSideEncryption = a.sse()
+	req.SSEKMSKeyId = a.sseKMSKeyId()
+	req.SSECustomerAlgorithm = a.sseCustomerAlgorithm()
+	req.SSECustomerKey = a.sseCustomerKey()
+}
+
+// md5InMetadata reports whether the md5sum has to be stored in metadata
+// because the ETag of the upload will not be the md5sum.
+func (a *AwsS3cp) md5InMetadata() bool {
+	return a.SSE == SSEKMS || a.SSECustomerKey != ""
+}
+
+// etagIsMD5 reports whether the ETag is the md5sum of the object
+// (or a multipart etag), which is not the case for SSE-KMS and SSE-C.
+func etagIsMD5(res *s3.HeadObjectOutput) bool {
+	if res.SSECustomerAlgorithm != nil {
+		return false
+	}
+	return aws.StringValue(res.ServerSideEncryption) != SSEKMS
+}
+
+// metaValue looks up user metadata; S3 returns the keys canonicalized.
+func metaValue(m map[string]*string, key string) string {
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package awscp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// StreamUpload uploads r of unknown length. r is read into the shared part
+// buffers of streamPartSize, and each buffer is uploaded as a part as soon
+// as it fills, so memory is bounded by the part buffers. A stream shorter
+// than PartSize is sent with a single PutObject.
+func (a *AwsS3cp) StreamUpload(r io.Reader) error {
+	read := func(num int64) (*[]byte, int, error) {
+		size := a.streamPartSize(num)
+		buf := getBuffer(size)
+		n, err := io.ReadFull(r, (*buf)[:size])
+		return buf, n, err
+	}
+
+	buf, n, err := read(1)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		defer putBuffer(buf)
+		return a.putObject(bytes.NewReader((*buf)[:n]), int64(n))
+	}
+	if err == nil {
+		err = a.createMultipartUpload()
+	}
+	if err != nil {
+		putBuffer(buf)
+		return err
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		parts   []s3.CompletedPart
+		partErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return partErr != nil
+	}
+	upload := func(buf *[]byte, n int, num int64) {
+		defer wg.Done()
+		part, err := a.uploadPartBytes((*buf)[:n], num)
+		putBuffer(buf)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			partErr = fmt.Errorf("%v [part:%d err:%v]", partErr, num, err)
+			return
+		}
+		parts = append(parts, part)
+	}
+	for num := int64(1); ; num++ {
+		wg.Add(1)
+		go upload(buf, n, num)
+		if err == io.ErrUnexpectedEOF || failed() {
+			break
+		}
+		if num == maxParts {
+			err = fmt.Errorf("the stream is over the S3 limit of %d parts", maxParts)
+			break
+		}
+		buf, n, err = read(num + 1)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			putBuffer(buf)
+			break
+		}
+	}
+	wg.Wait()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err == nil {
+		err = partErr
+	}
+	if err == nil {
+		err = a.completeMultipartUpload(parts)
+	}
+	if err != nil {
+		// A stream can not be resumed.
+		a.abortMultipartUpload()
+	}
+	return err
+}
+
+// streamPartSize returns the size of part num. The length of a stream is
+// not known, so the size doubles every 1000 parts to reach the 5TiB object
+// limit within maxParts.
+func (a *AwsS3cp) streamPartSize(num int64) int64 {
+	size := a.PartSize << uint((num-1)/1000)
+	if size > maxPartSize {
+		size = maxPartSize
+	}
+	return size
+}
+
+func (a *AwsS3cp) uploadPartBytes(b []byte, num int64) (s3.CompletedPart, error) {
+	a.Log.Info("Start upload Part section Num:%d", num)
+	req := s3.UploadPartInput{
+		Body:          bytes.NewReader(b),
+		Bucket:        aws.String(a.Bucket),
+		ContentLength: aws.Int64(int64(len(b))),
+		Key:           aws.String(a.S3Path),
+		PartNumber:    aws.Int64(num),
+		UploadId:      a.UploadId,
+	}
+	a.setUploadPartSSE(&req)
+	resp, err := a.client.UploadPart(&req)
+	if err != nil {
+		a.Log.Warning("UploadPart err Part Num:%d err: %v", num, err)
+		return s3.CompletedPart{}, err
+	}
+	a.Log.Info("uploaded Part section Num:%d", num)
+	return s3.CompletedPart{ETag: resp.ETag, PartNumber: aws.Int64(num)}, nil
+}
+
+func (a *AwsS3cp) abortMultipartUpload() {
+	_, err := a.client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(a.Bucket),
+		Key:      aws.String(a.S3Path),
+		UploadId: a.UploadId,
+	})
+	if err != nil {
+		a.Log.Warning("AbortMultipartUpload err:%v", err)
+	}
+}
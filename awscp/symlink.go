@@ -0,0 +1,43 @@
+package awscp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MetaSymlink holds the target of a symlink preserved as an empty object.
+const MetaSymlink = "s3cp-symlink"
+
+type S3SymlinkIsDifferentError struct {
+	S3Path   string
+	S3Target string
+	Target   string
+}
+
+func (e *S3SymlinkIsDifferentError) Error() string {
+	return fmt.Sprintf("%s symlink is %q != %q", e.S3Path, e.S3Target, e.Target)
+}
+
+func (a *AwsS3cp) compareSymlink() error {
+	res, err := a.head()
+	if err != nil {
+		return err
+	}
+	if target := metaValue(res.Metadata, MetaSymlink); target != a.SymlinkTarget {
+		return &S3SymlinkIsDifferentError{a.S3Path, target, a.SymlinkTarget}
+	}
+	return a.compareStorageClass(res)
+}
+
+// symlinkUpload uploads an empty object with the target of the symlink in metadata.
+func (a *AwsS3cp) symlinkUpload() (upload bool, err error) {
+	if err = a.compareSymlink(); err == nil {
+		if a.Retag {
+			a.Retagged, err = a.UpdateTags()
+		}
+		return false, err
+	}
+	a.SetMetadata(MetaSymlink, a.SymlinkTarget)
+	err = a.putObject(bytes.NewReader(nil), 0)
+	return err == nil, err
+}
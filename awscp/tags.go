@@ -0,0 +1,105 @@
+package awscp
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ParseTags parses "key=value[,key=value...]".
+func ParseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, errors.New("invalid tag: " + kv)
+		}
+		tags[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return tags, nil
+}
+
+func (a *AwsS3cp) SetTag(key, value string) {
+	if a.Tags == nil {
+		a.Tags = map[string]string{}
+	}
+	a.Tags[key] = value
+}
+
+// tagging returns the Tagging parameter of PutObject and CreateMultipartUpload.
+func (a *AwsS3cp) tagging() *string {
+	if len(a.Tags) == 0 {
+		return nil
+	}
+	v := url.Values{}
+	for k, t := range a.Tags {
+		v.Set(k, t)
+	}
+	return aws.String(v.Encode())
+}
+
+func (a *AwsS3cp) tagSet() []*s3.Tag {
+	keys := make([]string, 0, len(a.Tags))
+	for k := range a.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	set := make([]*s3.Tag, 0, len(keys))
+	for _, k := range keys {
+		set = append(set, &s3.Tag{Key: aws.String(k), Value: aws.String(a.Tags[k])})
+	}
+	return set
+}
+
+// setSourceTags sets Tags to the tags of SrcBucket/SrcKey.
+func (a *AwsS3cp) setSourceTags() error {
+	res, err := a.client.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(a.SrcBucket),
+		Key:    aws.String(a.SrcKey),
+	})
+	if err != nil {
+		return err
+	}
+	a.Tags = map[string]string{}
+	for _, t := range res.TagSet {
+		a.Tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return nil
+}
+
+// UpdateTags replaces the tags of the uploaded object when they differ from Tags.
+func (a *AwsS3cp) UpdateTags() (bool, error) {
+	res, err := a.client.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(a.Bucket),
+		Key:    aws.String(a.S3Path),
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(res.TagSet) == len(a.Tags) {
+		same := true
+		for _, t := range res.TagSet {
+			if v, ok := a.Tags[aws.StringValue(t.Key)]; !ok || v != aws.StringValue(t.Value) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return false, nil
+		}
+	}
+	_, err = a.client.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(a.Bucket),
+		Key:     aws.String(a.S3Path),
+		Tagging: &s3.Tagging{TagSet: a.tagSet()},
+	})
+	if err != nil {
+		a.Log.Warning("PutObjectTagging err:%v", err)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package awscp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/masahide/s3cp/file"
+)
+
+// VerifyUpload checks that the object has the contents of FilePath. The
+// file is hashed again instead of using the checksum cache, as the check
+// before a moved file is removed.
+func (a *AwsS3cp) VerifyUpload() error {
+	if a.SymlinkTarget != "" {
+		return a.compareSymlink()
+	}
+	f, err := os.Open(a.FilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if a.Compress != "" || a.EncryptKey != nil {
+		sum, err := file.Md5sum(f)
+		if err != nil {
+			return err
+		}
+		res, err := a.head()
+		if err != nil {
+			return err
+		}
+		s3size, _ := strconv.ParseInt(metaValue(res.Metadata, MetaOriginalSize), 10, 64)
+		if s3size != fi.Size() {
+			return &S3FileSizeIsDifferentError{a.S3Path, s3size, fi.Size()}
+		}
+		if s3md5 := metaValue(res.Metadata, MetaOriginalMD5); s3md5 != sum {
+			return &S3MD5sumIsDifferentError{a.S3Path, s3md5, sum}
+		}
+		return nil
+	}
+	var sum string
+	if fi.Size() > a.PartSize {
+		sum, err = MultipartEtag(f, a.PartSize)
+	} else {
+		sum, err = file.Md5sum(f)
+	}
+	if err != nil {
+		return err
+	}
+	res, err := a.head()
+	if err != nil {
+		return err
+	}
+	if !etagIsMD5(res) && metaValue(res.Metadata, MetaMD5) == "" {
+		// The ETag of SSE-KMS and SSE-C is not the md5sum: check the file
+		// against the parts hashed and sent by this upload instead.
+		if a.uploadSum == "" {
+			return fmt.Errorf("%s has no md5 to verify", a.S3Path)
+		}
+		if a.uploadSum != sum {
+			return &S3MD5sumIsDifferentError{a.S3Path, a.uploadSum, sum}
+		}
+		sum = ""
+	}
+	return a.compareObject(res, fi.Size(), sum)
+}
+
+// VerifyCopy checks that the object has the contents of SrcBucket/SrcKey by
+// the md5 that S3Copy read from the source, as the check before a source
+// skipped as the same is removed. A size alone does not tell the contents,
+// so a source without a known md5 is an error.
+func (a *AwsS3cp) VerifyCopy() error {
+	if a.md5sum == "" {
+		return fmt.Errorf("s3://%s/%s has no md5 to verify", a.SrcBucket, a.SrcKey)
+	}
+	return a.Exists(0, a.md5sum)
+}
@@ -0,0 +1,44 @@
+package awscp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/masahide/s3cp/logger"
+)
+
+func TestVerifyUploadKMS(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("ETag", `"0123456789abcdef0123456789abcdef"`)
+		w.Header().Set("X-Amz-Server-Side-Encryption", SSEKMS)
+	})
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		uploadSum string
+		ok        bool
+	}{
+		{"5d41402abc4b2a76b9719d911017c592", true},
+		{"00000000000000000000000000000000", false},
+		{"", false},
+	} {
+		a := &AwsS3cp{Bucket: "bucket", S3Path: "data", FilePath: path, PartSize: 5, Log: logger.NewLooger()}
+		a.SetS3client(client)
+		a.uploadSum = c.uploadSum
+		if err := a.VerifyUpload(); (err == nil) != c.ok {
+			t.Errorf("uploadSum %q: err = %v", c.uploadSum, err)
+		}
+	}
+}
@@ -83,10 +83,38 @@ func (c *S3) ListObjectsCallBack(req *s3.ListObjectsInput, dirCb func(*s3.Common
 			return nil
 		}
 		req.Marker = l.NextMarker
+		if req.Marker == nil && len(l.Contents) > 0 {
+			// NextMarker is only returned with a delimiter.
+			req.Marker = l.Contents[len(l.Contents)-1].Key
+		}
 	}
 	return nil
 }
 
+// ListObjectsV2 の callback版
+func (c *S3) ListObjectsV2CallBack(req *s3.ListObjectsV2Input, dirCb func(*s3.CommonPrefix) error, objectCb func(*s3.Object) error) error {
+	for {
+		l, err := c.ListObjectsV2(req)
+		if err != nil {
+			return err // give up retry.
+		}
+		for _, cp := range l.CommonPrefixes {
+			if err := dirCb(cp); err != nil {
+				return err
+			}
+		}
+		for _, object := range l.Contents {
+			if err := objectCb(object); err != nil {
+				return err
+			}
+		}
+		if !aws.BoolValue(l.IsTruncated) {
+			return nil
+		}
+		req.ContinuationToken = l.NextContinuationToken
+	}
+}
+
 /*
 func (c *S3) CreateMultipartUpload(req *s3.CreateMultipartUploadInput) (resp *s3.CreateMultipartUploadOutput, err error) {
 	return c.S3.CreateMultipartUpload(req)
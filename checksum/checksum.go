@@ -0,0 +1,196 @@
+package checksum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cache is a persistent cache of the md5sum and multipart etags of local
+// files, stored as JSON. An entry is valid while the size, mtime and inode
+// of the file are unchanged.
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]*Entry
+	// changed holds the keys set (true) or removed (false) since the
+	// last save, which are merged into the file.
+	changed map[string]bool
+}
+
+type Entry struct {
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"` // unix nano
+	Inode uint64 `json:"inode"`
+	MD5   string `json:"md5,omitempty"`
+	// ETags are the multipart etags by part size.
+	ETags map[int64]string `json:"etags,omitempty"`
+}
+
+// DefaultDir returns $XDG_STATE_HOME/s3cp or ~/.local/state/s3cp.
+func DefaultDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "s3cp")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "s3cp")
+}
+
+// Open reads the cache file; a missing file is an empty cache.
+func Open(path string) (*Cache, error) {
+	c := &Cache{path: path, changed: map[string]bool{}}
+	var err error
+	if c.entries, err = read(path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func read(path string) (map[string]*Entry, error) {
+	entries := map[string]*Entry{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func newEntry(fi os.FileInfo) *Entry {
+	return &Entry{
+		Size:  fi.Size(),
+		Mtime: fi.ModTime().UnixNano(),
+		Inode: inode(fi),
+	}
+}
+
+func (e *Entry) valid(fi os.FileInfo) bool {
+	n := newEntry(fi)
+	return e.Size == n.Size && e.Mtime == n.Mtime && e.Inode == n.Inode
+}
+
+func key(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Get returns the md5sum of the file, or the multipart etag for partSize > 0.
+// A stale entry is removed.
+func (c *Cache) Get(path string, fi os.FileInfo, partSize int64) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key(path)
+	e, ok := c.entries[k]
+	if !ok {
+		return "", false
+	}
+	if !e.valid(fi) {
+		delete(c.entries, k)
+		c.changed[k] = false
+		return "", false
+	}
+	sum := e.MD5
+	if partSize > 0 {
+		sum = e.ETags[partSize]
+	}
+	return sum, sum != ""
+}
+
+// Set stores the md5sum, or the multipart etag for partSize > 0.
+func (c *Cache) Set(path string, fi os.FileInfo, partSize int64, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key(path)
+	e, ok := c.entries[k]
+	if !ok || !e.valid(fi) {
+		e = newEntry(fi)
+		c.entries[k] = e
+	}
+	if partSize > 0 {
+		if e.ETags == nil {
+			e.ETags = map[int64]string{}
+		}
+		e.ETags[partSize] = sum
+	} else {
+		e.MD5 = sum
+	}
+	c.changed[k] = true
+}
+
+// Prune removes the entries of files which are removed or changed, and
+// returns the number of removed entries.
+func (c *Cache) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k, e := range c.entries {
+		if fi, err := os.Stat(k); err != nil || !e.valid(fi) {
+			delete(c.entries, k)
+			c.changed[k] = false
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Save merges the changes since the last save into the cache file. The
+// file is locked while it is read and rewritten, so that concurrent runs
+// keep the entries of each other.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.changed) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockFile(c.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := read(c.path)
+	if err != nil {
+		return err
+	}
+	for k, set := range c.changed {
+		if e, ok := c.entries[k]; set && ok {
+			entries[k] = e
+		} else {
+			delete(entries, k)
+		}
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.entries = entries
+	c.changed = map[string]bool{}
+	return nil
+}
@@ -0,0 +1,115 @@
+package checksum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(file)
+	cachePath := filepath.Join(dir, "state", "checksums.json")
+
+	c, err := Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set(file, fi, 0, "5d41402abc4b2a76b9719d911017c592")
+	c.Set(file, fi, 5*1024*1024, "etag-1")
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, ok := c.Get(file, fi, 0); !ok || sum != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("md5 = %q, %v", sum, ok)
+	}
+	if sum, ok := c.Get(file, fi, 5*1024*1024); !ok || sum != "etag-1" {
+		t.Errorf("etag = %q, %v", sum, ok)
+	}
+	if _, ok := c.Get(file, fi, 8*1024*1024); ok {
+		t.Error("etag of another part size is cached")
+	}
+
+	// A changed mtime invalidates the entry.
+	mtime := fi.ModTime().Add(time.Second)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	changed, _ := os.Stat(file)
+	if _, ok := c.Get(file, changed, 0); ok {
+		t.Error("stale entry is returned")
+	}
+	if c.Len() != 0 {
+		t.Errorf("stale entry is not removed: %d", c.Len())
+	}
+
+	c.Set(file, changed, 0, "sum")
+	c.Set(filepath.Join(dir, "removed"), fi, 0, "sum")
+	if n := c.Prune(); n != 1 {
+		t.Errorf("Prune() = %d, want 1", n)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", c.Len())
+	}
+}
+
+func TestCacheMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, f := range []string{a, b} {
+		if err := ioutil.WriteFile(f, []byte(f), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fa, _ := os.Stat(a)
+	fb, _ := os.Stat(b)
+	cachePath := filepath.Join(dir, "checksums.json")
+
+	// Two runs open the cache at the same time and set other files.
+	c1, err := Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.Set(a, fa, 0, "sum-a")
+	c2.Set(b, fb, 0, "sum-b")
+	if err := c1.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, ok := c.Get(a, fa, 0); !ok || sum != "sum-a" {
+		t.Errorf("a = %q, %v", sum, ok)
+	}
+	if sum, ok := c.Get(b, fb, 0); !ok || sum != "sum-b" {
+		t.Errorf("b = %q, %v", sum, ok)
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config file (~/.s3cp.toml) format. The keys are the flag names.
+//
+//	[default]
+//	region = "ap-northeast-1"
+//	n = 4
+//
+//	[buckets.log-bucket]
+//	storage-class = "STANDARD_IA"
+//
+//	[profiles.backup]
+//	ACL = "bucket-owner-full-control"
+//	tag = ["team=infra", "backup=true"]
+//
+//	[profiles.backup.buckets.log-bucket]
+//	sse = "aws:kms"
+//
+// Options are merged in the order default, profile, bucket, profile bucket;
+// the later one wins.
+type Config struct {
+	tables map[string]interface{}
+}
+
+type Options map[string]interface{}
+
+const EnvPrefix = "S3CP_"
+
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".s3cp.toml")
+}
+
+// Load reads the config file. A missing file is an empty config unless must is set.
+func Load(path string, must bool) (*Config, error) {
+	c := &Config{tables: map[string]interface{}{}}
+	if path == "" {
+		return c, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) && !must {
+		return c, nil
+	}
+	if _, err := toml.DecodeFile(path, &c.tables); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func table(m map[string]interface{}, keys ...string) map[string]interface{} {
+	for _, k := range keys {
+		t, ok := m[k].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		m = t
+	}
+	return m
+}
+
+// Options returns the options for profile and bucket.
+func (c *Config) Options(profile, bucket string) (Options, error) {
+	if profile != "" && profile != "default" && table(c.tables, "profiles", profile) == nil {
+		return nil, fmt.Errorf("profile not found: %s", profile)
+	}
+	opts := Options{}
+	for _, t := range []map[string]interface{}{
+		table(c.tables, "default"),
+		table(c.tables, "profiles", profile),
+		table(c.tables, "buckets", bucket),
+		table(c.tables, "profiles", profile, "buckets", bucket),
+	} {
+		for k, v := range t {
+			if k == "buckets" {
+				continue
+			}
+			opts[k] = v
+		}
+	}
+	return opts, nil
+}
+
+// Explicit returns the names of the flags set on the command line.
+func Explicit(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// EnvName returns the environment variable of a flag: "storage-class" is S3CP_STORAGE_CLASS.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// ApplyEnv sets the flags not in set from S3CP_* environment variables,
+// and adds them to set.
+func ApplyEnv(fs *flag.FlagSet, set map[string]bool) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		if v, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("%s: %v", EnvName(f.Name), err)
+			}
+			set[f.Name] = true
+		}
+	})
+	return err
+}
+
+// Apply sets the flags not in set from opts.
+func (opts Options) Apply(fs *flag.FlagSet, set map[string]bool) error {
+	for name, v := range opts {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option in config: %s", name)
+		}
+		if set[name] {
+			continue
+		}
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+		for _, v := range values {
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("config %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
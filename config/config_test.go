@@ -0,0 +1,86 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+[default]
+region = "us-east-1"
+n = 2
+
+[buckets.logs]
+storage-class = "STANDARD_IA"
+
+[profiles.backup]
+n = 8
+tag = ["team=infra", "backup=true"]
+
+[profiles.backup.buckets.logs]
+storage-class = "GLACIER"
+`
+
+type stringList []string
+
+func (s *stringList) String() string     { return "" }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+func TestApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s3cp.toml")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("s3cp", flag.ContinueOnError)
+	region := fs.String("region", "ap-northeast-1", "")
+	n := fs.Int("n", 1, "")
+	class := fs.String("storage-class", "", "")
+	var tags stringList
+	fs.Var(&tags, "tag", "")
+	if err := fs.Parse([]string{"-n", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("S3CP_REGION", "eu-west-1")
+	defer os.Unsetenv("S3CP_REGION")
+
+	set := Explicit(fs)
+	if err := ApplyEnv(fs, set); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := c.Options("backup", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.Apply(fs, set); err != nil {
+		t.Fatal(err)
+	}
+	if *n != 3 {
+		t.Errorf("n = %d, want 3 (command line)", *n)
+	}
+	if *region != "eu-west-1" {
+		t.Errorf("region = %s, want eu-west-1 (environment)", *region)
+	}
+	if *class != "GLACIER" {
+		t.Errorf("storage-class = %s, want GLACIER (profile bucket)", *class)
+	}
+	if len(tags) != 2 {
+		t.Errorf("tag = %v, want 2 tags", tags)
+	}
+
+	if _, err := c.Options("nothing", ""); err == nil {
+		t.Error("unknown profile accepted")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/masahide/s3cp/awscp"
+	"github.com/masahide/s3cp/awss3"
+	"github.com/masahide/s3cp/pipelines"
+)
+
+// copyObject copies a single s3://bucket/key on the server side.
+func copyObject(src, dest string) error {
+	srcBucket, srcKey, err := awscp.ParseS3URL(src)
+	if err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	to := dest
+	if strings.HasSuffix(dest, "/") {
+		to = dest + path.Base(srcKey)
+	}
+	t := copyTask{srcBucket: srcBucket, srcKey: srcKey, rel: path.Base(srcKey), dest: to}
+	r := t.copy()
+	if r.err != nil {
+		Log.Error("S3Copy err:%v", r.err)
+		return r.err
+	}
+	Log.Info("%v", r.GetMessage())
+	return nil
+}
+
+// GenCopyTask generates copy tasks for the objects under a prefix.
+type GenCopyTask struct {
+	srcBucket string
+	prefix    string
+	destPath  string
+	client    *awss3.S3
+}
+
+func newGenCopyTask(src, dest string) (*GenCopyTask, error) {
+	srcBucket, prefix, err := awscp.ParseS3URL(src)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &GenCopyTask{srcBucket, prefix, dest, &awss3.S3{S3: *S3client}}, nil
+}
+
+func (g *GenCopyTask) MakeTask(done <-chan struct{}, tasks chan<- pipelines.Task) error {
+	req := &s3.ListObjectsInput{
+		Bucket: aws.String(g.srcBucket),
+		Prefix: aws.String(g.prefix),
+	}
+	return g.client.ListObjectsCallBack(req, nil, func(o *s3.Object) error {
+		key := aws.StringValue(o.Key)
+		if strings.HasSuffix(key, "/") {
+			return nil // directory placeholder
+		}
+		rel := strings.TrimPrefix(key, g.prefix)
+		t := copyTask{
+			srcBucket: g.srcBucket,
+			srcKey:    key,
+			rel:       rel,
+			dest:      strings.TrimPrefix(g.destPath+"/"+rel, "/"),
+			size:      aws.Int64Value(o.Size),
+		}
+		select {
+		case tasks <- t:
+		case <-done:
+			return errors.New("Generate Task canceled")
+		}
+		return nil
+	})
+}
+
+type copyTask struct {
+	srcBucket string
+	srcKey    string
+	rel       string
+	dest      string
+	size      int64
+}
+
+func (t copyTask) copy() *s3cpResult {
+	result := &s3cpResult{from: "s3://" + t.srcBucket + "/" + t.srcKey, to: t.dest}
+	if moveFiles && t.srcBucket == bucket && t.srcKey == t.dest {
+		result.err = errors.New("can not move an object to itself")
+		return result
+	}
+	if moveKeys != nil {
+		moveKeys.add(t.dest, result.from)
+		return result
+	}
+	s3cp := newS3cp("", t.dest)
+	s3cp.SrcBucket = t.srcBucket
+	s3cp.SrcKey = t.srcKey
+	applyRules(s3cp, t.rel, t.size)
+	result.upload, result.err = s3cp.S3Copy()
+	result.retagged = s3cp.Retagged
+	if moveFiles && result.err == nil && !result.upload {
+		// Skipped as the same, which may be by the size only.
+		if err := s3cp.VerifyCopy(); err != nil {
+			result.err = fmt.Errorf("not removed, verify failed: %v", err)
+		}
+	}
+	if moveFiles && result.err == nil {
+		result.err = moveObject(t.srcBucket, t.srcKey)
+		result.moved = result.err == nil
+	}
+	return result
+}
+
+func (t copyTask) Work() pipelines.TaskResult {
+	return t.copy()
+}
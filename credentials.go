@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Credentials are refreshed this long before they expire, so that a
+// request of a long running upload never signs with expiring credentials.
+const credentialsExpiryWindow = 5 * time.Minute
+
+var (
+	awsProfile           = ""
+	roleArn              = ""
+	externalID           = ""
+	roleSessionName      = ""
+	roleDuration         = 60 // minutes
+	mfaSerial            = ""
+	webIdentityTokenFile = ""
+	stdinData            = false // stdin is the data of "-" or -files-from -
+)
+
+// tokenProvider reads the MFA code from the terminal, so that the prompt
+// does not consume the data on stdin.
+func tokenProvider() (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		if stdinData {
+			return "", errors.New("the MFA code needs a terminal when stdin is the data")
+		}
+		return stscreds.StdinTokenProvider()
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, "Assume Role MFA token code: ")
+	var code string
+	_, err = fmt.Fscanln(tty, &code)
+	return code, err
+}
+
+// newSession creates the session from the shared config of -profile
+// (including role_arn/source_profile/mfa_serial there), then assumes
+// -role-arn on top of it if given.
+func newSession(httpClient *http.Client) (*session.Session, error) {
+	bundle, err := caBundleReader()
+	if err != nil {
+		return nil, err
+	}
+	handlers := defaults.Handlers()
+	if dumpHTTP {
+		dumpHandlers(&handlers)
+	}
+	// The client and the handlers are given here so that the credentials
+	// of the shared config (role_arn, web identity) use them as well.
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:                 awsProfile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: tokenProvider,
+		Config:                  aws.Config{Region: aws.String(region), HTTPClient: httpClient},
+		Handlers:                handlers,
+		CustomCABundle:          bundle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if webIdentityTokenFile != "" && roleArn == "" {
+		return nil, errors.New("-web-identity-token-file requires -role-arn")
+	}
+	// AssumeRoleWithWebIdentity takes neither an external id nor MFA.
+	if webIdentityTokenFile != "" && (externalID != "" || mfaSerial != "") {
+		return nil, errors.New("-external-id and -mfa-serial can not be used with -web-identity-token-file")
+	}
+	if roleArn == "" {
+		return sess, nil
+	}
+	name := roleSessionName
+	if name == "" {
+		name = fmt.Sprintf("s3cp-%d", os.Getpid())
+	}
+	var creds *credentials.Credentials
+	if webIdentityTokenFile != "" {
+		p := stscreds.NewWebIdentityRoleProviderWithOptions(sts.New(sess), roleArn, name,
+			stscreds.FetchTokenPath(webIdentityTokenFile),
+			func(p *stscreds.WebIdentityRoleProvider) {
+				p.Duration = time.Duration(roleDuration) * time.Minute
+				p.ExpiryWindow = credentialsExpiryWindow
+			})
+		creds = credentials.NewCredentials(p)
+	} else {
+		creds = stscreds.NewCredentials(sess, roleArn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = name
+			p.Duration = time.Duration(roleDuration) * time.Minute
+			p.ExpiryWindow = credentialsExpiryWindow
+			if externalID != "" {
+				p.ExternalID = aws.String(externalID)
+			}
+			if mfaSerial != "" {
+				p.SerialNumber = aws.String(mfaSerial)
+				p.TokenProvider = tokenProvider
+			}
+		})
+	}
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/masahide/s3cp/awscp"
+)
+
+// downloadObject downloads s3://bucket/key to dest; "-" is stdout. A
+// directory dest gets the base name of the key.
+func downloadObject(src, dest string) error {
+	srcBucket, key, err := awscp.ParseS3URL(src)
+	if err == nil && (key == "" || strings.HasSuffix(key, "/")) {
+		err = errors.New("usage: s3cp <s3://bucket/path/to/filename> <local path|->")
+	}
+	if err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	bucket = srcBucket
+	s3cp := newS3cp("", key)
+	if dest == "-" {
+		err = s3cp.Download(os.Stdout)
+	} else {
+		if fi, e := os.Stat(dest); strings.HasSuffix(dest, "/") || e == nil && fi.IsDir() {
+			dest = filepath.Join(dest, filepath.Base(key))
+		}
+		err = s3cp.DownloadFile(dest)
+	}
+	if err != nil {
+		Log.Error("Download err:%v", err)
+		return err
+	}
+	Log.Info("Downloaded: %s", src)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/masahide/s3cp/awscp"
+)
+
+var dryRun = false
+
+type planEntry struct {
+	Path   string `json:"path"`
+	Key    string `json:"key"`
+	Action string `json:"action"`
+	Size   int64  `json:"size"`
+}
+
+// dryRunPlan collects the results of -dryrun.
+type dryRunPlan struct {
+	mu      sync.Mutex
+	Files   []planEntry     `json:"files"`
+	Actions map[string]int  `json:"actions"`
+	Bytes   int64           `json:"bytes"`
+	Log     json.RawMessage `json:"log,omitempty"`
+	verb    string
+}
+
+var plan = &dryRunPlan{Files: []planEntry{}, Actions: map[string]int{}, verb: "upload"}
+
+func (p *dryRunPlan) add(e planEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Files = append(p.Files, e)
+	p.Actions[e.Action]++
+	if e.Action != awscp.PlanSame {
+		p.Bytes += e.Size
+	}
+}
+
+// planFile compares the file with the object like FileUpload, and adds it to plan.
+func planFile(s3cp *awscp.AwsS3cp) (planEntry, error) {
+	e := planEntry{Path: s3cp.FilePath}
+	if s3cp.SymlinkTarget == "" {
+		e.Size = localSize(s3cp.FilePath)
+	}
+	action, err := s3cp.Plan()
+	if err != nil {
+		return e, err
+	}
+	e.Key = s3cp.S3Path
+	e.Action = action
+	plan.add(e)
+	return e, nil
+}
+
+func (p *dryRunPlan) summary() string {
+	names := make([]string, 0, len(p.Actions))
+	for name := range p.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := fmt.Sprintf("dryrun: %d files, %d bytes to %s", len(p.Files), p.Bytes, p.verb)
+	for _, name := range names {
+		s += fmt.Sprintf(" %s:%d", name, p.Actions[name])
+	}
+	return s
+}
+
+// writeJSON writes the plan with the log of -jsonLog.
+func (p *dryRunPlan) writeJSON(returnCode int) {
+	sort.Slice(p.Files, func(i, j int) bool { return p.Files[i].Path < p.Files[j].Path })
+	p.Log = Log.LogBufToJson(returnCode)
+	b, _ := json.MarshalIndent(p, "", "  ")
+	os.Stdout.Write(b)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/masahide/s3cp/pipelines"
+)
+
+var (
+	continueOnError = true
+	maxErrors       = 0
+	failures        = &failureList{}
+)
+
+type failure struct {
+	path   string
+	reason string
+}
+
+// failureList collects the paths which failed in directory mode.
+type failureList struct {
+	mu   sync.Mutex
+	list []failure
+}
+
+// add records the failure of path, and reports whether the run goes on:
+// unless -continue-on-error=false, until -max-errors failures.
+func (l *failureList) add(path, reason string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.list = append(l.list, failure{path, reason})
+	return continueOnError && (maxErrors <= 0 || len(l.list) < maxErrors)
+}
+
+func (l *failureList) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.list)
+}
+
+// resultFailures returns the failed paths of a result with reason.
+func resultFailures(result pipelines.TaskResult, reason string) []failure {
+	switch r := result.(type) {
+	case *s3cpResult:
+		return []failure{{r.from, reason}}
+	case *deleteResult:
+		return r.errs
+	}
+	return []failure{{"", reason}}
+}
+
+// report logs the skipped paths with the reasons.
+func (l *failureList) report() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.list) == 0 {
+		return
+	}
+	Log.Error("%d paths failed:", len(l.list))
+	for _, f := range l.list {
+		Log.Error("  %s: %s", f.path, f.reason)
+	}
+}
@@ -46,6 +46,9 @@ func ChooseNonEmpty(a string, b string) string {
 	return a
 }
 
+// ListFiles walks searchPath recursively, following symlinks up to MaxDepth.
+//
+// Deprecated: use WalkFiles, which detects symlink loops.
 func ListFiles(searchPath string, walkFn filepath.WalkFunc, symlinkDepth int) []error {
 	errors := []error{}
 	fi, err := os.Lstat(searchPath)
@@ -2,6 +2,7 @@ package file
 
 import (
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -43,3 +44,71 @@ func TestListFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestWalkFiles(t *testing.T) {
+	var mu sync.Mutex
+	found := map[string]bool{}
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		mu.Lock()
+		found[path] = true
+		mu.Unlock()
+		return err
+	}
+	errs := WalkFiles("test_dir", walkFn, WalkOptions{Workers: 4})
+	if len(errs) != 0 {
+		t.Error(errs)
+	}
+	if !found["test_dir/hoge"] {
+		t.Errorf("test_dir/hoge is not found: %v", found)
+	}
+
+	// The loop is skipped with a warning at the first level.
+	var warns []error
+	errs = WalkFiles("test_loopdir", walk, WalkOptions{
+		Workers: 4,
+		Warn: func(path string, err error) {
+			mu.Lock()
+			warns = append(warns, err)
+			mu.Unlock()
+		},
+	})
+	if len(errs) != 0 {
+		t.Error(errs)
+	}
+	if len(warns) != 1 {
+		t.Fatalf("warnings = %v", warns)
+	}
+	if serr, ok := warns[0].(*SymlinkLoopError); !ok || serr.Path != "test_loopdir/loop" || serr.Target != "test_loopdir" {
+		t.Errorf("warning = %v", warns[0])
+	}
+}
+
+func TestWalkFilesSymlinks(t *testing.T) {
+	for _, c := range []struct {
+		symlinks string
+		want     []string
+	}{
+		{SymlinkSkip, []string{"test_dir/hoge", "test_dir/testdir/fuga"}},
+		{SymlinkPreserve, []string{"test_dir/hoge", "test_dir/testdir/fuga", "test_dir/testlink1", "test_dir/testlink2"}},
+	} {
+		var mu sync.Mutex
+		found := map[string]bool{}
+		errs := WalkFiles("test_dir", func(path string, info os.FileInfo, err error) error {
+			mu.Lock()
+			found[path] = true
+			mu.Unlock()
+			return err
+		}, WalkOptions{Symlinks: c.symlinks})
+		if len(errs) != 0 {
+			t.Error(errs)
+		}
+		for _, p := range c.want {
+			if !found[p] {
+				t.Errorf("%s: %s is not found", c.symlinks, p)
+			}
+		}
+		if len(found) != len(c.want) {
+			t.Errorf("%s: found %v", c.symlinks, found)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package file
+
+import "os"
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func getFileID(fi os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,230 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ReadDirBatch is the number of entries WalkFiles reads from a directory at once.
+const ReadDirBatch = 1000
+
+// Symlink policies of WalkFiles.
+const (
+	SymlinkFollow   = "follow"
+	SymlinkSkip     = "skip"
+	SymlinkPreserve = "preserve"
+)
+
+type WalkOptions struct {
+	Workers int
+	// Symlinks is SymlinkFollow (default), SymlinkSkip, or SymlinkPreserve
+	// which calls walkFn with the symlink itself.
+	Symlinks string
+	// Warn is called for the skipped dangling symlinks and symlink loops.
+	Warn func(path string, err error)
+	// Done stops the walk when closed.
+	Done <-chan struct{}
+}
+
+type SymlinkLoopError struct {
+	Path   string
+	Target string
+}
+
+func (e *SymlinkLoopError) Error() string {
+	return fmt.Sprintf("%s: symlink loop to %s", e.Path, e.Target)
+}
+
+// dirNode is a directory and its ancestors, to detect loops.
+type dirNode struct {
+	path   string
+	id     fileID
+	parent *dirNode
+}
+
+func (n *dirNode) find(id fileID) *dirNode {
+	for ; n != nil; n = n.parent {
+		if n.id == id {
+			return n
+		}
+	}
+	return nil
+}
+
+type walkDir struct {
+	node         *dirNode
+	symlinkDepth int
+}
+
+type walker struct {
+	walkFn  filepath.WalkFunc
+	opts    WalkOptions
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []walkDir
+	pending int // directories queued or being read
+	errors  []error
+}
+
+// WalkFiles is a concurrent ListFiles. Directories are read by up to
+// opts.Workers goroutines in batches of ReadDirBatch entries, unsorted, and
+// walkFn is called for each file as soon as it is found; walkFn must be
+// safe to call from several goroutines. A directory which is its own
+// ancestor by device and inode is a loop and skipped; the symlink depth is
+// still limited by MaxDepth where inodes are not available.
+func WalkFiles(searchPath string, walkFn filepath.WalkFunc, opts WalkOptions) []error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if opts.Warn == nil {
+		opts.Warn = func(string, error) {}
+	}
+	w := &walker{walkFn: walkFn, opts: opts}
+	w.cond = sync.NewCond(&w.mu)
+	fi, err := os.Lstat(searchPath)
+	if err != nil {
+		return []error{walkFn(searchPath, fi, err)}
+	}
+	// The root is followed even if it is a symlink.
+	w.follow(nil, searchPath, fi, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+	return w.errors
+}
+
+func (w *walker) work() {
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && w.pending > 0 {
+			w.cond.Wait()
+		}
+		if w.pending == 0 {
+			w.mu.Unlock()
+			return
+		}
+		d := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.mu.Unlock()
+
+		w.readDir(d)
+
+		w.mu.Lock()
+		w.pending--
+		if w.pending == 0 {
+			w.cond.Broadcast()
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *walker) push(d walkDir) {
+	w.mu.Lock()
+	w.queue = append(w.queue, d)
+	w.pending++
+	w.mu.Unlock()
+	w.cond.Signal()
+}
+
+func (w *walker) addError(err error) {
+	if err == nil {
+		return
+	}
+	w.mu.Lock()
+	w.errors = append(w.errors, err)
+	w.mu.Unlock()
+}
+
+func (w *walker) readDir(d walkDir) {
+	dirPath := d.node.path
+	f, err := os.Open(dirPath)
+	if err != nil {
+		w.addError(w.walkFn(dirPath, nil, err))
+		return
+	}
+	defer f.Close()
+	for {
+		select {
+		case <-w.opts.Done:
+			return
+		default:
+		}
+		entries, err := f.ReadDir(ReadDirBatch)
+		for _, e := range entries {
+			fullPath := filepath.Join(dirPath, e.Name())
+			fi, err := e.Info()
+			if err != nil {
+				w.addError(w.walkFn(fullPath, fi, err))
+				continue
+			}
+			w.visit(d.node, fullPath, fi, d.symlinkDepth)
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			w.addError(w.walkFn(dirPath, nil, err))
+			return
+		}
+	}
+}
+
+// visit applies the symlink policy to an entry of parent.
+func (w *walker) visit(parent *dirNode, path string, fi os.FileInfo, symlinkDepth int) {
+	if IsSymlink(fi.Mode()) {
+		switch w.opts.Symlinks {
+		case SymlinkSkip:
+			return
+		case SymlinkPreserve:
+			w.addError(w.walkFn(path, fi, nil))
+			return
+		}
+	}
+	w.follow(parent, path, fi, symlinkDepth)
+}
+
+// follow queues a directory or calls walkFn for a regular file, following
+// a symlink.
+func (w *walker) follow(parent *dirNode, path string, fi os.FileInfo, symlinkDepth int) {
+	if IsSymlink(fi.Mode()) {
+		var err error
+		fi, err = os.Stat(path)
+		if os.IsNotExist(err) {
+			w.opts.Warn(path, err)
+			return
+		}
+		if err != nil {
+			w.addError(w.walkFn(path, fi, err))
+			return
+		}
+		symlinkDepth++
+		if symlinkDepth > MaxDepth {
+			w.addError(&ListFilesError{path})
+			return
+		}
+	}
+	if fi.IsDir() {
+		node := &dirNode{path: path, parent: parent}
+		if id, ok := getFileID(fi); ok {
+			if a := parent.find(id); a != nil {
+				w.opts.Warn(path, &SymlinkLoopError{path, a.path})
+				return
+			}
+			node.id = id
+		}
+		w.push(walkDir{node, symlinkDepth})
+	} else if fi.Mode().IsRegular() {
+		w.addError(w.walkFn(path, fi, nil))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/masahide/s3cp/file"
+	"github.com/masahide/s3cp/pipelines"
+)
+
+var (
+	filesFrom = ""
+	nulSep    = false
+	// filesFromData is the list of -files-from - when it is read ahead.
+	filesFromData []byte
+)
+
+// GenFilesFromTask generates upload tasks for the files listed in -files-from,
+// one path per line (or NUL separated with -0). A path is relative to root,
+// or absolute under root, and the key is destPath/<path relative to root>.
+type GenFilesFromTask struct {
+	list     io.Reader
+	root     string
+	destPath string
+}
+
+func newGenFilesFromTask(list, root, dest string) (*GenFilesFromTask, error) {
+	g := &GenFilesFromTask{root: filepath.Clean(root), destPath: dest}
+	if list == "-" {
+		g.list = os.Stdin
+		if filesFromData != nil {
+			g.list = bytes.NewReader(filesFromData)
+		}
+		return g, nil
+	}
+	f, err := os.Open(list)
+	if err != nil {
+		return nil, err
+	}
+	g.list = f
+	return g, nil
+}
+
+func scanNul(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func (g *GenFilesFromTask) MakeTask(done <-chan struct{}, tasks chan<- pipelines.Task) error {
+	if c, ok := g.list.(io.Closer); ok && g.list != os.Stdin {
+		defer c.Close()
+	}
+	scanner := bufio.NewScanner(g.list)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if nulSep {
+		scanner.Split(scanNul)
+	}
+	for scanner.Scan() {
+		name := scanner.Text()
+		if !nulSep {
+			name = strings.TrimRight(name, "\r")
+		}
+		if name == "" {
+			continue
+		}
+		t, err := g.task(name)
+		if err != nil {
+			Log.Error("Error Path:%s, err=[ %s ]", name, err)
+			if failures.add(name, err.Error()) {
+				continue
+			}
+			return err
+		}
+		if t == nil {
+			continue
+		}
+		select {
+		case tasks <- *t:
+		case <-done:
+			return nil // aborted, the cause is reported already
+		}
+	}
+	return scanner.Err()
+}
+
+func (g *GenFilesFromTask) task(name string) (*s3cpTask, error) {
+	full := name
+	if !filepath.IsAbs(name) {
+		full = filepath.Join(g.root, name)
+	}
+	rel, err := filepath.Rel(g.root, full)
+	if err != nil {
+		return nil, err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, errors.New("not under " + g.root)
+	}
+	fi, err := os.Lstat(full)
+	if err != nil {
+		return nil, err
+	}
+	symlink := file.IsSymlink(fi.Mode())
+	if symlink {
+		switch symlinks {
+		case file.SymlinkSkip:
+			return nil, nil
+		case file.SymlinkFollow:
+			if fi, err = os.Stat(full); err != nil {
+				return nil, err
+			}
+			symlink = false
+		}
+	}
+	if fi.IsDir() {
+		Log.Warning("skip directory: %s", full)
+		return nil, nil
+	}
+	return &s3cpTask{path: full, root: g.root, dest: g.destPath, rel: filepath.ToSlash(rel), symlink: symlink, queued: keyTime()}, nil
+}
@@ -0,0 +1,116 @@
+package keytemplate
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Template computes an S3 key from the attributes of a file, such as
+// "{date}/{hostname}/{relpath}" or "{sha256}.{ext}". The fields are:
+//
+//	{relpath}   path relative to the source directory
+//	{dir}       directory of relpath ("." for none)
+//	{name}      base name
+//	{basename}  base name without the extension
+//	{ext}       extension without the dot
+//	{date}      upload date, 2006-01-02
+//	{year} {month} {day} {hour}
+//	{hostname}
+//	{md5} {sha256}  hex digest of the content
+type Template struct {
+	text   string
+	fields map[string]bool
+}
+
+var fields = map[string]bool{
+	"relpath": true, "dir": true, "name": true, "basename": true, "ext": true,
+	"date": true, "year": true, "month": true, "day": true, "hour": true,
+	"hostname": true, "md5": true, "sha256": true,
+}
+
+func Parse(text string) (*Template, error) {
+	t := &Template{text: text, fields: map[string]bool{}}
+	for s := text; ; {
+		i := strings.Index(s, "{")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(s[i:], "}")
+		if j < 0 {
+			return nil, fmt.Errorf("key template: unclosed { in %q", text)
+		}
+		name := s[i+1 : i+j]
+		if !fields[name] {
+			return nil, fmt.Errorf("key template: unknown field {%s}", name)
+		}
+		t.fields[name] = true
+		s = s[i+j+1:]
+	}
+	return t, nil
+}
+
+// NeedsContent reports whether the key depends on the content of the file.
+func (t *Template) NeedsContent() bool {
+	return t.fields["md5"] || t.fields["sha256"]
+}
+
+// Key returns the key of the file at filePath, rel being its slash
+// separated path relative to the source directory.
+func (t *Template) Key(filePath, rel string, now time.Time) (string, error) {
+	name := path.Base(rel)
+	ext := path.Ext(name)
+	v := map[string]string{
+		"relpath":  rel,
+		"dir":      path.Dir(rel),
+		"name":     name,
+		"basename": strings.TrimSuffix(name, ext),
+		"ext":      strings.TrimPrefix(ext, "."),
+		"date":     now.Format("2006-01-02"),
+		"year":     now.Format("2006"),
+		"month":    now.Format("01"),
+		"day":      now.Format("02"),
+		"hour":     now.Format("15"),
+	}
+	if t.fields["hostname"] {
+		host, err := os.Hostname()
+		if err != nil {
+			return "", err
+		}
+		v["hostname"] = host
+	}
+	if t.NeedsContent() {
+		if err := digest(filePath, v); err != nil {
+			return "", err
+		}
+	}
+	pairs := make([]string, 0, len(v)*2)
+	for k, s := range v {
+		pairs = append(pairs, "{"+k+"}", s)
+	}
+	return strings.NewReplacer(pairs...).Replace(t.text), nil
+}
+
+// digest reads the file once for both digests.
+func digest(filePath string, v map[string]string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, s := md5.New(), sha256.New()
+	if _, err = io.Copy(io.MultiWriter(m, s), f); err != nil {
+		return err
+	}
+	for k, h := range map[string]hash.Hash{"md5": m, "sha256": s} {
+		v[k] = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
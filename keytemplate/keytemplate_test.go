@@ -0,0 +1,48 @@
+package keytemplate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 9, 7, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		text, rel, want string
+	}{
+		{"{date}/{relpath}", "logs/app.log", "2024-03-09/logs/app.log"},
+		{"{year}/{month}/{day}/{hour}/{name}", "logs/app.log", "2024/03/09/07/app.log"},
+		{"{dir}/{basename}.{ext}", "logs/app.log", "logs/app.log"},
+		{"{sha256}.{ext}", "app.tar.gz", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824.gz"},
+		{"{md5}", "app.tar.gz", "5d41402abc4b2a76b9719d911017c592"},
+	} {
+		tmpl, err := Parse(c.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tmpl.Key(file, c.rel, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s: Key(%s) = %s, want %s", c.text, c.rel, got, c.want)
+		}
+	}
+	for _, text := range []string{"{unknown}", "{date"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) succeeded", text)
+		}
+	}
+}
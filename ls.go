@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/masahide/s3cp/awscp"
+	"github.com/masahide/s3cp/awss3"
+)
+
+var (
+	lsRecursive = false
+	lsHuman     = false
+	lsJSON      = false
+)
+
+func init() {
+	subcommands["ls"] = &subcommand{
+		usage: "[-r] [-h] [-json] [options] <s3://bucket/prefix>",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&lsRecursive, "r", lsRecursive, "list all objects under the prefix")
+			fs.BoolVar(&lsHuman, "h", lsHuman, "human readable sizes")
+			fs.BoolVar(&lsJSON, "json", lsJSON, "output JSON lines")
+		},
+		run: listObjects,
+	}
+}
+
+type lsObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	StorageClass string    `json:"storage_class"`
+	ETag         string    `json:"etag"`
+}
+
+type lsPrefix struct {
+	Prefix string `json:"prefix"`
+}
+
+type lsTotal struct {
+	Objects int64 `json:"total_objects"`
+	Size    int64 `json:"total_size"`
+}
+
+// lister prints a listing as columns, or as JSON lines with the total on
+// the last line.
+type lister struct {
+	w     *bufio.Writer
+	enc   *json.Encoder
+	dir   string // the part of the prefix trimmed from the names
+	total lsTotal
+}
+
+func newLister(w io.Writer, prefix string) *lister {
+	l := &lister{w: bufio.NewWriter(w)}
+	if lsJSON {
+		l.enc = json.NewEncoder(l.w)
+	}
+	if !lsRecursive {
+		l.dir = prefix[:strings.LastIndex(prefix, "/")+1]
+	}
+	return l
+}
+
+func (l *lister) size(n int64) string {
+	if lsHuman {
+		return humanSize(n)
+	}
+	return fmt.Sprint(n)
+}
+
+func (l *lister) prefix(p *s3.CommonPrefix) error {
+	if l.enc != nil {
+		return l.enc.Encode(lsPrefix{aws.StringValue(p.Prefix)})
+	}
+	_, err := fmt.Fprintf(l.w, "%50s %s\n", "PRE", strings.TrimPrefix(aws.StringValue(p.Prefix), l.dir))
+	return err
+}
+
+func (l *lister) object(o *s3.Object) error {
+	l.total.Objects++
+	l.total.Size += aws.Int64Value(o.Size)
+	obj := lsObject{
+		Key:          aws.StringValue(o.Key),
+		Size:         aws.Int64Value(o.Size),
+		LastModified: aws.TimeValue(o.LastModified),
+		StorageClass: aws.StringValue(o.StorageClass),
+		ETag:         strings.Trim(aws.StringValue(o.ETag), `"`),
+	}
+	if l.enc != nil {
+		return l.enc.Encode(obj)
+	}
+	_, err := fmt.Fprintf(l.w, "%s %-19s %10s %-34s %s\n",
+		obj.LastModified.Local().Format("2006-01-02 15:04:05"),
+		obj.StorageClass, l.size(obj.Size), obj.ETag,
+		strings.TrimPrefix(obj.Key, l.dir))
+	return err
+}
+
+func (l *lister) finish() error {
+	if l.enc != nil {
+		if err := l.enc.Encode(l.total); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(l.w, "\nTotal Objects: %d\n   Total Size: %s\n", l.total.Objects, l.size(l.total.Size))
+	}
+	return l.w.Flush()
+}
+
+// listObjects lists s3://bucket/prefix. Without -r the common prefixes
+// are shown as directories.
+func listObjects(args []string) error {
+	if len(args) != 1 || !awscp.IsS3URL(args[0]) {
+		return errors.New("ls needs one s3://bucket/prefix")
+	}
+	b, prefix, err := awscp.ParseS3URL(args[0])
+	if err != nil {
+		return err
+	}
+	req := &s3.ListObjectsV2Input{
+		Bucket: aws.String(b),
+		Prefix: aws.String(prefix),
+	}
+	if !lsRecursive {
+		req.Delimiter = awss3.Delimiter
+	}
+	l := newLister(os.Stdout, prefix)
+	c := &awss3.S3{S3: *S3client}
+	if err = c.ListObjectsV2CallBack(req, l.prefix, l.object); err != nil {
+		l.w.Flush()
+		return err
+	}
+	return l.finish()
+}
+
+// humanSize formats n in binary units: 1536 is "1.5 KiB".
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
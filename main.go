@@ -5,22 +5,25 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/masahide/gobackoff"
 	"github.com/masahide/s3cp/awscp"
+	"github.com/masahide/s3cp/checksum"
+	"github.com/masahide/s3cp/config"
 	"github.com/masahide/s3cp/file"
+	"github.com/masahide/s3cp/keytemplate"
 	"github.com/masahide/s3cp/logger"
 	"github.com/masahide/s3cp/pipelines"
+	"github.com/masahide/s3cp/rules"
 )
 
 var (
@@ -32,6 +35,11 @@ var (
 	cpPath                   = ""
 	destPath                 = ""
 	dirCopy                  = false
+	walkers                  = 4
+	symlinks                 = file.SymlinkFollow
+	keyTemplateText          = ""
+	keyTemplate              *keytemplate.Template
+	startTime                = time.Now()
 	logLevel                 = 0
 	jsonLog                  = false
 	showVersion              = false
@@ -41,23 +49,52 @@ var (
 	RetryMaxInterval         = 60        //60 * time.Second
 	RetryMaxElapsedTime      = 15        //15 * time.Minute
 	Acl                      = "private" //
+	sse                      = ""
+	sseKMSKeyId              = ""
+	sseCustomerKeyFile       = ""
+	sseCustomerKey           = ""
+	storageClass             = ""
+	storageClassRulesFile    = ""
+	storageClassRules        rules.Rules
+	headers                  stringsFlag
+	metadata                 stringsFlag
+	headerRulesFile          = ""
+	headerRules              rules.Rules
+	tags                     stringsFlag
+	tagRulesFile             = ""
+	tagRules                 rules.Rules
+	retag                    = false
+	metadataDirective        = s3.MetadataDirectiveCopy
+	compressGzip             = false
+	compressZstd             = false
+	compressInclude          stringsFlag
+	compressSuffix           = false
+	encryptKeyFile           = ""
+	encryptKey               []byte
+	compare                  = awscp.CompareSize
+	stateDir                 = checksum.DefaultDir()
+	checksumCache            = true
+	pruneCache               = false
+	checksums                *checksum.Cache
+	preserveMode             = false
+	configFile               = config.DefaultPath()
+	configProfile            = ""
 	version                  string
 	Log                      *logger.Logger
 	S3client                 *s3.S3
 	Backoff                  gobackoff.BackOff
 )
 
-type DebugTransport struct {
-	http.Transport
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
 }
 
-func (t *DebugTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	//r, _ := httputil.DumpRequestOut(req, true)
-	resp, err = t.Transport.RoundTrip(req)
-	//res, _ := httputil.DumpResponse(resp, true)
-	//log.Printf("req:%s\nres:%s\n", r) //, res)
-	//log.Printf("\nreq:\n%s\n", r) //, res)
-	return resp, err
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
 }
 
 func main() {
@@ -69,40 +106,255 @@ func main() {
 	flag.BoolVar(&jsonLog, "jsonLog", jsonLog, "JSON output")
 	flag.StringVar(&region, "region", region, "region")
 	flag.StringVar(&Acl, "ACL", Acl, "ACL 'private,public-read,public-read-write,authenticated-read,bucket-owner-full-control,bucket-owner-read")
+	flag.StringVar(&sse, "sse", sse, "server-side encryption 'AES256,aws:kms'")
+	flag.StringVar(&sseKMSKeyId, "sse-kms-key-id", sseKMSKeyId, "KMS key id for -sse aws:kms")
+	flag.StringVar(&sseCustomerKeyFile, "sse-c-key-file", sseCustomerKeyFile, "SSE-C customer key file (32 bytes)")
+	flag.StringVar(&storageClass, "storage-class", storageClass, "storage class 'STANDARD,STANDARD_IA,ONEZONE_IA,INTELLIGENT_TIERING,GLACIER_IR,GLACIER,DEEP_ARCHIVE,REDUCED_REDUNDANCY'")
+	flag.StringVar(&storageClassRulesFile, "storage-class-rules", storageClassRulesFile, "storage class rules file ('<glob|>=size> <class>' per line)")
+	flag.Var(&headers, "header", "header 'Name: value' (Cache-Control,Content-Type,Content-Encoding,Content-Disposition,Content-Language,Expires,x-amz-meta-*), repeatable")
+	flag.Var(&metadata, "metadata", "user metadata 'key=value', repeatable")
+	flag.StringVar(&headerRulesFile, "header-rules", headerRulesFile, "header rules file ('<glob|>=size> <Name: value>' per line)")
+	flag.Var(&tags, "tag", "object tag 'key=value', repeatable")
+	flag.StringVar(&tagRulesFile, "tags-from", tagRulesFile, "tag rules file ('<glob|>=size> key=value[,key=value]' per line)")
+	flag.BoolVar(&retag, "retag", retag, "update tags of unchanged objects")
+	flag.StringVar(&metadataDirective, "metadata-directive", metadataDirective, "s3 to s3 copy: 'COPY' preserves the source headers and metadata, 'REPLACE' uses the options")
+	flag.BoolVar(&compressGzip, "gzip", compressGzip, "gzip files while uploading")
+	flag.BoolVar(&compressZstd, "zstd", compressZstd, "zstd files while uploading")
+	flag.Var(&compressInclude, "compress-include", "compress only files matching the glob pattern, repeatable")
+	flag.BoolVar(&compressSuffix, "compress-suffix", compressSuffix, "append .gz/.zst to the key instead of setting Content-Encoding")
+	flag.StringVar(&encryptKeyFile, "encrypt-key-file", encryptKeyFile, "client-side encryption key file (32 bytes), AES-256-GCM")
+	flag.StringVar(&compare, "compare", compare, "'size' compares by -checksize/-checkmd5, 'mtime' by size and the mtime stored at upload")
+	flag.BoolVar(&preserveMode, "preserve-mode", preserveMode, "store the file mode and uid/gid in metadata, restored by download")
+	flag.StringVar(&stateDir, "state-dir", stateDir, "directory of the checksum cache")
+	flag.BoolVar(&checksumCache, "checksum-cache", checksumCache, "cache the md5sum of files by path, size, mtime and inode")
+	flag.BoolVar(&pruneCache, "prune-cache", pruneCache, "remove the cache entries of changed or removed files, and exit")
 	flag.IntVar(&workNum, "n", workNum, "max workers")
+	flag.IntVar(&walkers, "walkers", walkers, "max goroutines reading directories with -r")
+	flag.StringVar(&keyTemplateText, "key-template", keyTemplateText, "key under the s3 path from fields such as {date}/{hostname}/{relpath} or {sha256}.{ext}")
+	flag.BoolVar(&watch, "watch", watch, "with -r, keep uploading the files changed in the directory (inotify)")
+	flag.IntVar(&watchDebounce, "watch-debounce", watchDebounce, "-watch uploads a file after no change for this (Second)")
+	flag.IntVar(&watchRescan, "watch-rescan", watchRescan, "-watch walks the whole directory every N minutes (0 is never)")
+	flag.StringVar(&filesFrom, "files-from", filesFrom, "upload the files listed in the file ('-' is stdin), relative to the src directory")
+	flag.BoolVar(&nulSep, "0", nulSep, "-files-from is NUL separated")
+	flag.BoolVar(&continueOnError, "continue-on-error", continueOnError, "with -r, skip the paths which fail and exit with 2 at the end; false stops at the first error")
+	flag.IntVar(&maxErrors, "max-errors", maxErrors, "with -r, abort after N failures (0 is unlimited)")
+	flag.StringVar(&symlinks, "symlinks", symlinks, "symlinks with -r: 'follow', 'skip', or 'preserve' as empty objects with the target in metadata")
+	flag.BoolVar(&dryRun, "dryrun", dryRun, "show what would be uploaded without uploading")
 	flag.IntVar(&RetryInitialInterval, "RetryInitialInterval", RetryInitialInterval, "Retry Initial Interval")
 	flag.Float64Var(&RetryRandomizationFactor, "RetryRandomizationFactor", RetryRandomizationFactor, "Retry Randomization Factor")
 	flag.Float64Var(&RetryMultiplier, "RetryMultiplier", RetryMultiplier, "Retry Multiplier")
 	flag.IntVar(&RetryMaxInterval, "RetryMaxInterval", RetryMaxInterval, "Retry Max Interval")
 	flag.IntVar(&RetryMaxElapsedTime, "RetryMaxElapsedTime", RetryMaxElapsedTime, "Retry Max Elapsed Time")
 
+	flag.StringVar(&awsProfile, "profile", awsProfile, "AWS shared config/credentials profile")
+	flag.StringVar(&roleArn, "role-arn", roleArn, "assume the IAM role")
+	flag.StringVar(&externalID, "external-id", externalID, "external id for -role-arn")
+	flag.StringVar(&roleSessionName, "session-name", roleSessionName, "role session name for -role-arn (default s3cp-<pid>)")
+	flag.IntVar(&roleDuration, "role-duration", roleDuration, "role session duration (Minute), refreshed before expiry")
+	flag.StringVar(&mfaSerial, "mfa-serial", mfaSerial, "MFA device for -role-arn, the token code is read from the terminal")
+	flag.StringVar(&webIdentityTokenFile, "web-identity-token-file", webIdentityTokenFile, "web identity token file for -role-arn")
+	flag.IntVar(&connectTimeout, "connect-timeout", connectTimeout, "connect timeout (Second)")
+	flag.IntVar(&readTimeout, "read-timeout", readTimeout, "timeout of a read or write without progress (Second), 0 is none")
+	flag.IntVar(&totalTimeout, "timeout", totalTimeout, "timeout of a request (Second), 0 is none")
+	flag.BoolVar(&accelerate, "accelerate", accelerate, "use S3 Transfer Acceleration endpoint")
+	flag.BoolVar(&dualstack, "dualstack", dualstack, "use dual-stack (IPv6) endpoint")
+	flag.StringVar(&proxy, "proxy", proxy, "HTTP proxy URL (default HTTPS_PROXY/HTTP_PROXY env)")
+	flag.StringVar(&caBundle, "ca-bundle", caBundle, "PEM file of the CA certificates instead of the system ones (default AWS_CA_BUNDLE env)")
+	flag.BoolVar(&dumpHTTP, "dump-http", dumpHTTP, "dump HTTP request/response headers, credentials are redacted")
 	flag.IntVar(&logLevel, "d", logLevel, "log level")
+	flag.StringVar(&configFile, "config", configFile, "config file")
+	flag.StringVar(&configProfile, "config-profile", configProfile, "profile of the config file")
 
-	flag.Parse()
+	sub := parseFlags()
+	if err := loadConfig(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 
 	if showVersion {
 		fmt.Printf("version: %s\n", version)
 		return
 	}
+	if pruneCache {
+		if err := pruneChecksums(); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	if flag.NArg() < 3 {
+	download := sub == nil && flag.NArg() == 2 && awscp.IsS3URL(flag.Arg(0))
+	if sub == nil && flag.NArg() < 3 && !download {
 		fmt.Printf("Usage:\n")
 		fmt.Printf(" %s [options] <src path/to/filename> <bucket> <s3 path/to/filename>\n", path.Base(os.Args[0]))
+		fmt.Printf(" %s [-r] [options] <src path>... <bucket> <s3 dir path>\n", path.Base(os.Args[0]))
 		fmt.Printf(" %s -r [options] <src local dir path> <bucket> <s3 path>\n", path.Base(os.Args[0]))
+		fmt.Printf(" %s -files-from <list|-> [-0] [options] <src local dir path> <bucket> <s3 path>\n", path.Base(os.Args[0]))
+		fmt.Printf(" %s [-r] [options] <s3://src-bucket/path> <bucket> <s3 path>\n", path.Base(os.Args[0]))
+		fmt.Printf(" %s [options] - <bucket> <s3 path/to/filename>  (upload stdin)\n", path.Base(os.Args[0]))
+		fmt.Printf(" %s [options] <s3://bucket/path/to/filename> <local path|->  (download, - is stdout)\n", path.Base(os.Args[0]))
+		for _, name := range []string{"ls", "rm", "mv"} {
+			fmt.Printf(" %s %s %s\n", path.Base(os.Args[0]), name, subcommands[name].usage)
+		}
 		fmt.Printf("Options:\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
-	cpPath = flag.Args()[0]
-	bucket = flag.Args()[1]
-	destPath = flag.Args()[2]
+	if sub != nil && sub.copyArgs && flag.NArg() < 3 {
+		flag.CommandLine.Usage()
+		os.Exit(1)
+	}
 
-	httpClient := &http.Client{
-		Timeout:   time.Duration(5) * time.Second,
-		Transport: &DebugTransport{http.Transport{MaxIdleConnsPerHost: 32}},
+	var err error
+	if sseCustomerKeyFile != "" {
+		if sseCustomerKey, err = awscp.ReadSSECustomerKey(sseCustomerKeyFile); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	if err = awscp.ValidateSSE(sse, sseKMSKeyId, sseCustomerKey); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if metadataDirective != s3.MetadataDirectiveCopy && metadataDirective != s3.MetadataDirectiveReplace {
+		log.Println("-metadata-directive must be COPY or REPLACE")
+		os.Exit(1)
+	}
+	if encryptKeyFile != "" {
+		if encryptKey, err = awscp.ReadEncryptKey(encryptKeyFile); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		if compressGzip || compressZstd {
+			log.Println("-encrypt-key-file can not be used with -gzip or -zstd")
+			os.Exit(1)
+		}
+	}
+	if compressGzip && compressZstd {
+		log.Println("-gzip and -zstd can not be used together")
+		os.Exit(1)
+	}
+	if symlinks != file.SymlinkFollow && symlinks != file.SymlinkSkip && symlinks != file.SymlinkPreserve {
+		log.Println("-symlinks must be follow, skip or preserve")
+		os.Exit(1)
+	}
+	if compare != awscp.CompareSize && compare != awscp.CompareMtime {
+		log.Println("-compare must be size or mtime")
+		os.Exit(1)
+	}
+	var srcs []string
+	switch {
+	case sub != nil && !sub.copyArgs:
+	case download:
+		srcs = flag.Args()[:1]
+	default:
+		srcs = flag.Args()[:flag.NArg()-2]
+	}
+	for _, src := range srcs {
+		if len(srcs) > 1 && src == "-" {
+			log.Println("stdin can not be one of several sources")
+			os.Exit(1)
+		}
+		local := !download && !awscp.IsS3URL(src) && src != "-"
+		if keyTemplateText != "" && !local {
+			log.Println("-key-template is supported only for uploading local files")
+			os.Exit(1)
+		}
+		if filesFrom != "" && !local {
+			log.Println("-files-from needs a local src directory")
+			os.Exit(1)
+		}
+		if dryRun && !local {
+			log.Println("-dryrun is supported only for uploading local files")
+			os.Exit(1)
+		}
+		if encryptKey != nil && !download && !local {
+			log.Println("-encrypt-key-file is supported only for uploading local files")
+			os.Exit(1)
+		}
+		if (compressGzip || compressZstd) && !download && !local {
+			log.Println("-gzip and -zstd are supported only for uploading local files")
+			os.Exit(1)
+		}
+	}
+	stdinData = filesFrom == "-" || len(srcs) == 1 && srcs[0] == "-"
+	if watch && (sub != nil && !sub.copyArgs || !dirCopy || len(srcs) > 1 || filesFrom != "" || dryRun || awscp.IsS3URL(srcs[0]) || srcs[0] == "-") {
+		log.Println("-watch needs -r and one local src directory")
+		os.Exit(1)
+	}
+	if watch {
+		// A long running watch skips the failed files, they are retried on change.
+		continueOnError = true
+	}
+	if filesFrom != "" && len(srcs) > 1 {
+		log.Println("-files-from takes one src directory")
+		os.Exit(1)
+	}
+	if keyTemplateText != "" {
+		if keyTemplate, err = keytemplate.Parse(keyTemplateText); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	for _, p := range compressInclude {
+		if _, err = path.Match(p, ""); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	if storageClassRulesFile != "" {
+		if storageClassRules, err = rules.Load(storageClassRulesFile); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	if headerRulesFile != "" {
+		if headerRules, err = rules.Load(headerRulesFile); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	for _, h := range append(headerRules.Values(), headers...) {
+		if _, _, err = awscp.ParseHeader(h); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	for _, m := range metadata {
+		if _, _, err = awscp.ParseMetadata(m); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	if tagRulesFile != "" {
+		if tagRules, err = rules.Load(tagRulesFile); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	for _, t := range append(tagRules.Values(), tags...) {
+		if _, err = awscp.ParseTags(t); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	for _, class := range append(storageClassRules.Values(), storageClass) {
+		if class == "" {
+			continue
+		}
+		if err = awscp.ValidateStorageClass(class); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
 	}
 	lt := aws.LogLevelType(logLevel)
-	sess, err := session.NewSession()
+	sess, err := newSession(httpClient)
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
@@ -112,6 +364,7 @@ func main() {
 		HTTPClient: httpClient,
 		LogLevel:   &lt,
 	}
+	endpointConfig(conf)
 
 	//S3client = s3.New(aws.DetectCreds("", "", ""), region, client)
 	S3client = s3.New(sess, conf)
@@ -127,83 +380,310 @@ func main() {
 	} else {
 		Log = logger.NewLoogerLevel(logLevel)
 	}
-	Log.Notice("copy %s -> %s:%s", cpPath, bucket, destPath)
-
 	cpus := runtime.NumCPU()
 	runtime.GOMAXPROCS(cpus)
+	// The part buffers are shared by all the files, -n of them in total.
+	if workNum > 0 {
+		awscp.SetPartBuffers(workNum)
+	}
 
-	if dirCopy {
-		cpPath = strings.TrimSuffix(cpPath, `/`)
-		destPath = strings.TrimSuffix(destPath, `/`)
+	jsonOut := os.Stdout
+	if sub != nil {
+		if err = sub.run(flag.Args()); err != nil {
+			Log.Error("%v", err)
+		}
+	} else if download {
+		if flag.Arg(1) == "-" {
+			jsonOut = os.Stderr
+		}
+		err = downloadObject(flag.Arg(0), flag.Arg(1))
+	} else {
+		args := flag.Args()
+		bucket = args[len(args)-2]
+		err = copySources(args[:len(args)-2], args[len(args)-1])
+	}
+	if checksums != nil {
+		if err := checksums.Save(); err != nil {
+			Log.Warning("save checksum cache: %v", err)
+		}
+	}
+	failures.report()
+	returnCode := 0
+	if err != nil {
+		returnCode = 1
+	} else if failures.len() > 0 {
+		returnCode = 2
+	}
+	if dryRun {
+		Log.Notice("%s", plan.summary())
+	}
+	if jsonLog && dryRun {
+		plan.writeJSON(returnCode)
+	} else if jsonLog {
+		jsonOut.Write(Log.LogBufToJson(returnCode))
+	}
+	os.Exit(returnCode)
+}
 
-		// Generate Task
-		done := make(chan struct{})
-		defer close(done)
-		gt := &GenUploadTask{cpPath, destPath, Log}
-		tasks, errc := pipelines.GenerateTask(done, gt)
+// loadConfig sets the flags not given on the command line from S3CP_*
+// environment variables, then from the profile of the config file.
+func loadConfig() error {
+	set := config.Explicit(flag.CommandLine)
+	if err := config.ApplyEnv(flag.CommandLine, set); err != nil {
+		return err
+	}
+	c, err := config.Load(configFile, set["config"])
+	if err != nil {
+		return err
+	}
+	opts, err := c.Options(configProfile, configBucket())
+	if err != nil {
+		return err
+	}
+	return opts.Apply(flag.CommandLine, set)
+}
+
+func checksumPath() string {
+	return filepath.Join(stateDir, "checksums.json")
+}
 
-		// Start workers
-		results := make(chan pipelines.TaskResult)
-		var wg sync.WaitGroup
-		wg.Add(workNum)
-		for i := 0; i < workNum; i++ {
-			go func() {
-				pipelines.Worker(done, tasks, results)
-				wg.Done()
-			}()
+func pruneChecksums() error {
+	c, err := checksum.Open(checksumPath())
+	if err != nil {
+		return err
+	}
+	n := c.Prune()
+	if err = c.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("pruned %d entries, %d entries left\n", n, c.Len())
+	return nil
+}
+
+// checksumSaveInterval is the interval to save the checksum cache, so that
+// a long run or -watch keeps the sums when it is killed.
+const checksumSaveInterval = time.Minute
+
+// openChecksums opens the checksum cache when the copy reads the files
+// for a hash, and saves it periodically.
+func openChecksums() {
+	if !checksumCache || checksums != nil {
+		return
+	}
+	if !checkMD5 && compression() == "" && encryptKey == nil && !moveFiles {
+		return
+	}
+	var err error
+	if checksums, err = checksum.Open(checksumPath()); err != nil {
+		Log.Warning("checksum cache is disabled: %v", err)
+		return
+	}
+	go func(c *checksum.Cache) {
+		for range time.Tick(checksumSaveInterval) {
+			if err := c.Save(); err != nil {
+				Log.Warning("save checksum cache: %v", err)
+			}
 		}
+	}(checksums)
+}
 
-		// wait work
-		go func() {
-			wg.Wait()
-			close(results)
-		}()
+// configBucket returns the target bucket for the bucket overrides of the config file.
+func configBucket() string {
+	if flag.NArg() <= 2 && awscp.IsS3URL(flag.Arg(0)) {
+		b, _, _ := awscp.ParseS3URL(flag.Arg(0))
+		return b
+	}
+	return flag.Arg(flag.NArg() - 2)
+}
+
+// copySources copies each source to bucket:dest. With several sources
+// dest is a directory, and a directory source keeps its name under it as
+// with cp -r.
+func copySources(srcs []string, dest string) error {
+	openChecksums()
+	var lastErr error
+	for _, src := range srcs {
+		cpPath, destPath = src, dest
+		if len(srcs) > 1 {
+			destPath = strings.TrimSuffix(dest, "/") + "/"
+			if dirCopy {
+				destPath += path.Base(strings.TrimSuffix(src, "/"))
+			}
+			destPath = strings.TrimPrefix(destPath, "/")
+		}
+		if moveKeys == nil {
+			Log.Notice("copy %s -> %s:%s", cpPath, bucket, destPath)
+		}
+		if err := copyFiles(); err != nil {
+			if !continueOnError {
+				return err
+			}
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// templateKey returns the -key-template key of the local file under dest,
+// with the time fields of t.
+func templateKey(dest, filePath, rel string, t time.Time) (string, error) {
+	key, err := keyTemplate.Key(filePath, rel, t)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(dest, "/")+"/"+key, "/"), nil
+}
 
-		// Merge results
-		for result := range results {
-			Log.Info("%v", result.GetMessage())
+// copyFiles copies cpPath to bucket:destPath.
+func copyFiles() (err error) {
+	if filesFrom != "" {
+		destPath = strings.TrimSuffix(destPath, `/`)
+		var gt *GenFilesFromTask
+		if gt, err = newGenFilesFromTask(filesFrom, cpPath, destPath); err == nil {
+			err = runTasks(gt)
+		}
+		if err != nil {
+			Log.Error("Error: %v", err)
 		}
+	} else if dirCopy {
+		cpPath = strings.TrimSuffix(cpPath, `/`)
+		destPath = strings.TrimSuffix(destPath, `/`)
 
-		// Check whether the work failed.
-		if err = <-errc; err != nil {
+		var gt pipelines.GenTask = &GenUploadTask{cpPath, destPath, Log}
+		var wt *GenWatchTask
+		if awscp.IsS3URL(cpPath) {
+			gt, err = newGenCopyTask(cpPath, destPath)
+		} else if watch {
+			// Watch before the first sync so that no change is missed.
+			wt, err = newGenWatchTask(cpPath, destPath)
+		}
+		if err == nil {
+			err = runTasks(gt)
+		}
+		if err == nil && wt != nil {
+			Log.Notice("watching %s", cpPath)
+			err = runTasks(wt)
+		}
+		if err != nil {
 			Log.Error("Error: %v", err)
 		}
+	} else if awscp.IsS3URL(cpPath) {
+		err = copyObject(cpPath, destPath)
+	} else if cpPath == "-" {
+		err = streamUpload(destPath)
 	} else {
-		s3cp := awscp.AwsS3cp{
-			Bucket:    bucket,
-			S3Path:    destPath,
-			Acl:       Acl,
-			MimeType:  "application/octet-stream",
-			PartSize:  20 * 1024 * 1024,
-			CheckSize: checkSize,
-			CheckMD5:  checkMD5,
-			WorkNum:   workNum,
-			Log:       Log,
-			FilePath:  cpPath,
-		}
-		if strings.HasSuffix(destPath, "/") {
-			s3cp.S3Path = destPath + path.Base(cpPath)
-		}
-		s3cp.SetS3client(S3client)
+		to := destPath
+		if keyTemplate != nil {
+			if to, err = templateKey(destPath, cpPath, path.Base(cpPath), startTime); err != nil {
+				Log.Error("key template err:%v", err)
+				return err
+			}
+		} else if strings.HasSuffix(destPath, "/") {
+			to = destPath + path.Base(cpPath)
+		}
+		if moveKeys != nil {
+			moveKeys.add(to, cpPath)
+			return nil
+		}
+		s3cp := newS3cp(cpPath, to)
+		applyRules(s3cp, path.Base(cpPath), localSize(cpPath))
+		if dryRun {
+			var e planEntry
+			if e, err = planFile(s3cp); err != nil {
+				Log.Error("dryrun err:%v", err)
+			} else {
+				Log.Notice("%s: %s -> %s", e.Action, e.Path, e.Key)
+			}
+			return err
+		}
 		var upload bool
 		upload, err = s3cp.FileUpload()
 		if err != nil {
 			Log.Error("FileUpload err:%v", err)
+		} else if moveFiles {
+			if err = moveLocal(s3cp); err != nil {
+				Log.Error("move err:%v", err)
+			} else {
+				Log.Info("Moved: %s", cpPath)
+			}
+		} else if s3cp.Retagged {
+			Log.Info("Retagged: %s", destPath)
 		} else if !upload {
 			Log.Info("Same file: %s", destPath)
 		} else {
 			Log.Info("Uploaded.")
 		}
 	}
-	returnCode := 0
-	if err != nil {
-		returnCode = 1
+	return err
+}
+
+// streamUpload uploads stdin to the key dest.
+func streamUpload(dest string) error {
+	if dest == "" || strings.HasSuffix(dest, "/") {
+		err := errors.New("upload from stdin needs a s3 file name")
+		Log.Error("%v", err)
+		return err
 	}
-	if jsonLog {
-		os.Stdout.Write(Log.LogBufToJson(returnCode))
+	s3cp := newS3cp("-", dest)
+	applyRules(s3cp, path.Base(dest), 0)
+	if err := s3cp.StreamUpload(os.Stdin); err != nil {
+		Log.Error("StreamUpload err:%v", err)
+		return err
+	}
+	Log.Info("Uploaded.")
+	return nil
+}
+
+// runTasks runs the tasks generated by gt on workNum workers.
+func runTasks(gt pipelines.GenTask) error {
+	// Generate Task
+	done := make(chan struct{})
+	defer close(done)
+	tasks, errc := pipelines.GenerateTask(done, gt)
+
+	// Start workers
+	results := make(chan pipelines.TaskResult)
+	var wg sync.WaitGroup
+	wg.Add(workNum)
+	for i := 0; i < workNum; i++ {
+		go func() {
+			pipelines.Worker(done, tasks, results)
+			wg.Done()
+		}()
+	}
+
+	// wait work
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Merge results
+	logResult := Log.Info
+	if dryRun {
+		logResult = Log.Notice
+	}
+	if moveKeys != nil {
+		logResult = logger.NullLogger
+	}
+	for result := range results {
+		if reason := result.Error(); reason != "" {
+			if r, ok := result.(*deleteResult); ok && len(r.deleted) > 0 {
+				logResult("%v", r.GetMessage())
+			}
+			for _, f := range resultFailures(result, reason) {
+				Log.Error("Error Path:%s, err=[ %s ]", f.path, f.reason)
+				if !failures.add(f.path, f.reason) {
+					return fmt.Errorf("%s: %s", f.path, f.reason)
+				}
+			}
+			continue
+		}
+		logResult("%v", result.GetMessage())
 	}
-	os.Exit(returnCode)
 
+	// Check whether the work failed.
+	return <-errc
 }
 
 type GenUploadTask struct {
@@ -213,75 +693,241 @@ type GenUploadTask struct {
 }
 
 func (g *GenUploadTask) MakeTask(done <-chan struct{}, tasks chan<- pipelines.Task) error {
-	errs := file.ListFiles(
+	// stop ends the walk when the run is aborted.
+	stop := make(chan struct{})
+	var once sync.Once
+	abort := func() { once.Do(func() { close(stop) }) }
+	go func() {
+		select {
+		case <-done:
+			abort()
+		case <-stop:
+		}
+	}()
+	defer abort()
+
+	errs := file.WalkFiles(
 		g.cpPath,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				g.Log.Error("Error Path:%s, err=[ %s ]", path, err)
+				if failures.add(path, err.Error()) {
+					return nil
+				}
+				abort()
 				return err
 			}
 			select {
-			case tasks <- s3cpTask{path: path, root: g.cpPath, dest: g.destPath}:
-			case <-done:
-				return errors.New("Generate Task canceled")
+			case tasks <- s3cpTask{path: path, root: g.cpPath, dest: g.destPath, symlink: file.IsSymlink(info.Mode()), queued: keyTime()}:
+			case <-stop:
+				return nil // aborted, the cause is reported already
 			}
 			return nil
 		},
-		0,
+		file.WalkOptions{
+			Workers:  walkers,
+			Symlinks: symlinks,
+			Warn: func(path string, err error) {
+				g.Log.Warning("skip %s: %v", path, err)
+			},
+			Done: stop,
+		},
 	)
-	if len(errs) > 0 {
-		errmsg := ""
-		for _, err := range errs {
-			errmsg += err.Error() + "\n"
+	errmsg := ""
+	for _, err := range errs {
+		if lerr, ok := err.(*file.ListFilesError); ok && failures.add(lerr.Path, err.Error()) {
+			continue
 		}
+		errmsg += err.Error() + "\n"
+	}
+	if errmsg != "" {
 		return errors.New(errmsg)
-	} else {
-		return nil
 	}
+	return nil
 }
 
 type s3cpTask struct {
-	path string
-	root string
-	dest string
+	path    string
+	root    string
+	dest    string
+	rel     string    // path relative to root, if known
+	symlink bool      // -symlinks preserve
+	queued  time.Time // time of the -key-template fields, see keyTime
+}
+
+// keyTime returns the time of the -key-template fields of a task queued
+// now: the start of the run, or the current time with -watch so that the
+// keys of a long running watch roll over to the new {date}.
+func keyTime() time.Time {
+	if watch {
+		return time.Now()
+	}
+	return startTime
 }
 
 type s3cpResult struct {
-	task   s3cpTask
-	to     string
-	upload bool
-	err    error
+	from     string
+	to       string
+	upload   bool
+	retagged bool
+	moved    bool
+	action   string // -dryrun
+	err      error
 }
 
 func (r *s3cpResult) Error() string {
+	if r.err == nil {
+		return ""
+	}
 	return r.err.Error()
 }
 func (r *s3cpResult) GetMessage() string {
+	if r.action != "" {
+		return fmt.Sprintf("%s: %s -> %s", r.action, r.from, r.to)
+	}
+	if r.moved {
+		return fmt.Sprintf("move: %s -> %s", r.from, r.to)
+	}
+	if r.upload && awscp.IsS3URL(r.from) {
+		return fmt.Sprintf("copy: %s -> %s", r.from, r.to)
+	}
 	if r.upload {
 		return fmt.Sprintf("upload: %s", r.to)
 	}
+	if r.retagged {
+		return fmt.Sprintf("retag: %s", r.to)
+	}
 	return fmt.Sprintf("Same file: %s", r.to)
 }
 
 func (t s3cpTask) Work() pipelines.TaskResult {
-	to := t.dest + `/` + strings.TrimPrefix(strings.TrimPrefix(t.path, t.root), `/`)
+	rel := t.rel
+	if rel == "" {
+		rel = strings.TrimPrefix(strings.TrimPrefix(t.path, t.root), `/`)
+	}
+	to := t.dest + `/` + rel
 	//log.Printf("t.path:%s", t.path)
-	result := s3cpResult{task: t}
-
-	s3cp := awscp.AwsS3cp{
-		Bucket:    bucket,
-		S3Path:    to,
-		MimeType:  "application/octet-stream",
-		PartSize:  20 * 1024 * 1024,
-		CheckSize: checkSize,
-		CheckMD5:  checkMD5,
-		WorkNum:   workNum,
-		Log:       Log,
-		FilePath:  t.path,
+	result := s3cpResult{from: t.path, to: to}
+	if keyTemplate != nil {
+		if to, result.err = templateKey(t.dest, t.path, rel, t.queued); result.err != nil {
+			return &result
+		}
 	}
-	s3cp.SetS3client(S3client)
+	if moveKeys != nil {
+		moveKeys.add(to, t.path)
+		result.to = to
+		return &result
+	}
+
+	s3cp := newS3cp(t.path, to)
+	applyRules(s3cp, rel, localSize(t.path))
 	result.to = to
+	if t.symlink {
+		if s3cp.SymlinkTarget, result.err = os.Readlink(t.path); result.err != nil {
+			return &result
+		}
+	}
+	if dryRun {
+		var e planEntry
+		e, result.err = planFile(s3cp)
+		result.to, result.action = e.Key, e.Action
+		return &result
+	}
 	result.upload, result.err = s3cp.FileUpload()
+	result.retagged = s3cp.Retagged
+	if moveFiles && result.err == nil {
+		result.err = moveLocal(s3cp)
+		result.moved = result.err == nil
+	}
 
 	return &result
 }
+
+func newS3cp(filePath, to string) *awscp.AwsS3cp {
+	s3cp := &awscp.AwsS3cp{
+		Bucket:          bucket,
+		S3Path:          to,
+		Acl:             Acl,
+		MimeType:        "application/octet-stream",
+		PartSize:        20 * 1024 * 1024,
+		CheckSize:       checkSize,
+		CheckMD5:        checkMD5,
+		WorkNum:         workNum,
+		Log:             Log,
+		FilePath:        filePath,
+		SSE:             sse,
+		SSEKMSKeyId:     sseKMSKeyId,
+		SSECustomerKey:  sseCustomerKey,
+		StorageClass:    storageClass,
+		Retag:           retag,
+		ReplaceMetadata: metadataDirective == s3.MetadataDirectiveReplace,
+		EncryptKey:      encryptKey,
+		Compare:         compare,
+		PreserveMode:    preserveMode,
+	}
+	if checksums != nil {
+		s3cp.Checksums = checksums
+	}
+	for _, m := range metadata {
+		k, v, _ := awscp.ParseMetadata(m)
+		s3cp.SetMetadata(k, v)
+	}
+	for _, h := range headers {
+		name, v, _ := awscp.ParseHeader(h)
+		s3cp.SetHeader(name, v)
+	}
+	for _, t := range tags {
+		m, _ := awscp.ParseTags(t)
+		for k, v := range m {
+			s3cp.SetTag(k, v)
+		}
+	}
+	s3cp.SetS3client(S3client)
+	return s3cp
+}
+
+// applyRules applies the rules files and -compress-include; rel is the
+// path the rules are matched against.
+func applyRules(s3cp *awscp.AwsS3cp, rel string, size int64) {
+	if compress := compression(); compress != "" {
+		match := len(compressInclude) == 0
+		for _, p := range compressInclude {
+			match = match || rules.MatchPath(p, rel)
+		}
+		if match {
+			s3cp.Compress = compress
+			s3cp.CompressSuffix = compressSuffix
+		}
+	}
+	if class := storageClassRules.Last(rel, size); class != "" {
+		s3cp.StorageClass = class
+	}
+	for _, h := range headerRules.Match(rel, size) {
+		name, v, _ := awscp.ParseHeader(h)
+		s3cp.SetHeader(name, v)
+	}
+	for _, t := range tagRules.Match(rel, size) {
+		m, _ := awscp.ParseTags(t)
+		for k, v := range m {
+			s3cp.SetTag(k, v)
+		}
+	}
+}
+
+func compression() string {
+	switch {
+	case compressGzip:
+		return awscp.CompressGzip
+	case compressZstd:
+		return awscp.CompressZstd
+	}
+	return ""
+}
+
+func localSize(filePath string) int64 {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
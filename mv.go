@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/masahide/s3cp/awscp"
+)
+
+// moveFiles removes the sources after they are copied, set by mv.
+var moveFiles = false
+
+// moveKeys collects the destination keys of the sources before mv moves
+// anything. While it is set, the tasks only record their key.
+var moveKeys *keySet
+
+type keySet struct {
+	mu   sync.Mutex
+	srcs map[string]string
+	dups []string
+}
+
+// add records that src goes to key, or the collision with an earlier src.
+func (s *keySet) add(key, src string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if first, ok := s.srcs[key]; ok {
+		s.dups = append(s.dups, fmt.Sprintf("%s and %s are both moved to s3://%s/%s", first, src, bucket, key))
+		return
+	}
+	s.srcs[key] = src
+}
+
+func init() {
+	subcommands["mv"] = &subcommand{
+		usage:    "[-r] [options] <src path|s3://bucket/path>... <bucket> <s3 path>",
+		run:      moveSources,
+		copyArgs: true,
+	}
+}
+
+// moveSources copies like copySources, and removes each source once its
+// copy is done and verified.
+func moveSources(args []string) error {
+	srcs, dest := args[:len(args)-2], args[len(args)-1]
+	bucket = args[len(args)-2]
+	for _, src := range srcs {
+		if src == "-" {
+			return errors.New("mv can not move stdin")
+		}
+		// Objects moved under the listed prefix would be listed again.
+		if b, key, err := awscp.ParseS3URL(src); err == nil && b == bucket && dirCopy &&
+			(key == "" || strings.HasPrefix(strings.TrimSuffix(dest, "/")+"/", strings.TrimSuffix(key, "/")+"/")) {
+			return fmt.Errorf("can not move %s into itself", src)
+		}
+	}
+	if watch {
+		return errors.New("-watch can not be used with mv")
+	}
+	moveFiles = true
+	if err := checkMoveKeys(srcs, dest); err != nil {
+		return err
+	}
+	return copySources(srcs, dest)
+}
+
+// checkMoveKeys refuses the move when two sources go to the same key, as
+// the second would overwrite the first after it is removed.
+func checkMoveKeys(srcs []string, dest string) error {
+	if filesFrom == "-" {
+		// The list is read twice.
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		filesFromData = data
+	}
+	keys := &keySet{srcs: map[string]string{}}
+	moveKeys = keys
+	err := copySources(srcs, dest)
+	moveKeys = nil
+	// The failed paths are reported by the move.
+	failures = &failureList{}
+	if err != nil {
+		return err
+	}
+	if len(keys.dups) > 0 {
+		for _, d := range keys.dups {
+			Log.Error("%s", d)
+		}
+		return fmt.Errorf("nothing is moved, %d sources go to the same key as another", len(keys.dups))
+	}
+	return nil
+}
+
+// moveLocal removes the uploaded file after VerifyUpload, unless the file
+// is changed during the check.
+func moveLocal(s3cp *awscp.AwsS3cp) error {
+	stat := os.Stat
+	if s3cp.SymlinkTarget != "" {
+		stat = os.Lstat
+	}
+	before, err := stat(s3cp.FilePath)
+	if err != nil {
+		return err
+	}
+	if err = s3cp.VerifyUpload(); err != nil {
+		return fmt.Errorf("not removed, verify failed: %v", err)
+	}
+	after, err := stat(s3cp.FilePath)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(before, after) || !before.ModTime().Equal(after.ModTime()) || before.Size() != after.Size() {
+		return fmt.Errorf("not removed, changed while moving: %s", s3cp.FilePath)
+	}
+	if s3cp.SymlinkTarget != "" {
+		if target, err := os.Readlink(s3cp.FilePath); err != nil || target != s3cp.SymlinkTarget {
+			return fmt.Errorf("not removed, changed while moving: %s", s3cp.FilePath)
+		}
+	}
+	return os.Remove(s3cp.FilePath)
+}
+
+// moveObject deletes the source object once it is copied.
+func moveObject(srcBucket, srcKey string) error {
+	_, err := S3client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(srcKey),
+	})
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/masahide/s3cp/keytemplate"
+	"github.com/masahide/s3cp/logger"
+)
+
+func TestCheckMoveKeys(t *testing.T) {
+	Log = logger.NewLooger()
+	checksumCache = false
+	bucket = "bucket"
+	dir, err := ioutil.TempDir("", "s3cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a/x", "b/x", "b/y"} {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0700)
+		if err := ioutil.WriteFile(p, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ax, bx, by := filepath.Join(dir, "a/x"), filepath.Join(dir, "b/x"), filepath.Join(dir, "b/y")
+	onlyName, _ := keytemplate.Parse("{name}")
+
+	for _, c := range []struct {
+		srcs     []string
+		dirCopy  bool
+		template *keytemplate.Template
+		ok       bool
+	}{
+		{[]string{ax, by}, false, nil, true},
+		{[]string{ax, bx}, false, nil, false},
+		{[]string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}, true, nil, true},
+		{[]string{dir}, true, nil, true},
+		{[]string{dir}, true, onlyName, false},
+	} {
+		dirCopy, keyTemplate = c.dirCopy, c.template
+		if err := checkMoveKeys(c.srcs, "dest/"); (err == nil) != c.ok {
+			t.Errorf("%v -r=%v: err = %v", c.srcs, c.dirCopy, err)
+		}
+	}
+	dirCopy, keyTemplate = false, nil
+}
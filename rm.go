@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/masahide/s3cp/awscp"
+	"github.com/masahide/s3cp/awss3"
+	"github.com/masahide/s3cp/pipelines"
+	"github.com/masahide/s3cp/rules"
+)
+
+// DeleteObjects takes up to 1000 keys.
+const deleteBatchSize = 1000
+
+const planDelete = "delete"
+
+var (
+	rmRecursive   = false
+	rmInclude     stringsFlag
+	rmExclude     stringsFlag
+	rmConfirmOver = 1000
+	rmYes         = false
+)
+
+func init() {
+	subcommands["rm"] = &subcommand{
+		usage: "[-r] [-include pattern] [-exclude pattern] [-dryrun] [-yes] [options] <s3://bucket/key|prefix>",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&rmRecursive, "r", rmRecursive, "delete all objects under the prefix")
+			fs.Var(&rmInclude, "include", "delete only keys matching the glob pattern, repeatable")
+			fs.Var(&rmExclude, "exclude", "do not delete keys matching the glob pattern, repeatable")
+			fs.IntVar(&rmConfirmOver, "confirm-over", rmConfirmOver, "ask before deleting more objects than this")
+			fs.BoolVar(&rmYes, "yes", rmYes, "do not ask for confirmation")
+		},
+		run: removeObjects,
+	}
+}
+
+// rmMatch reports whether the key at rel under the prefix is deleted by
+// -include and -exclude, matched as in the rules files.
+func rmMatch(rel string) bool {
+	match := len(rmInclude) == 0
+	for _, p := range rmInclude {
+		match = match || rules.MatchPath(p, rel)
+	}
+	for _, p := range rmExclude {
+		match = match && !rules.MatchPath(p, rel)
+	}
+	return match
+}
+
+// removeObjects deletes s3://bucket/key, or with -r the objects under the
+// prefix. The objects are listed before anything is deleted, so that the
+// confirmation shows the count.
+func removeObjects(args []string) error {
+	if len(args) != 1 || !awscp.IsS3URL(args[0]) {
+		return errors.New("rm needs one s3://bucket/key")
+	}
+	b, key, err := awscp.ParseS3URL(args[0])
+	if err != nil {
+		return err
+	}
+	for _, p := range append(rmInclude, rmExclude...) {
+		if _, err = path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	var objects []*s3.Object
+	prefix := key
+	if !rmRecursive {
+		if key == "" || strings.HasSuffix(key, "/") {
+			return errors.New("use -r to delete the objects under a prefix")
+		}
+		// The key is matched from the root of the bucket.
+		if rmMatch(key) {
+			objects = append(objects, &s3.Object{Key: aws.String(key)})
+		}
+	} else {
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		req := &s3.ListObjectsV2Input{
+			Bucket: aws.String(b),
+			Prefix: aws.String(prefix),
+		}
+		c := &awss3.S3{S3: *S3client}
+		err = c.ListObjectsV2CallBack(req, nil, func(o *s3.Object) error {
+			if rmMatch(strings.TrimPrefix(aws.StringValue(o.Key), prefix)) {
+				objects = append(objects, o)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		plan.verb = planDelete
+		for _, o := range objects {
+			plan.add(planEntry{Key: aws.StringValue(o.Key), Action: planDelete, Size: aws.Int64Value(o.Size)})
+			Log.Notice("%s: s3://%s/%s", planDelete, b, aws.StringValue(o.Key))
+		}
+		return nil
+	}
+	if len(objects) == 0 {
+		Log.Info("no objects to delete: s3://%s/%s", b, prefix)
+		return nil
+	}
+	root := rmRecursive && prefix == ""
+	if !rmYes && (root || len(objects) > rmConfirmOver) {
+		msg := fmt.Sprintf("delete %d objects under s3://%s/%s?", len(objects), b, prefix)
+		if root {
+			msg = fmt.Sprintf("delete %d objects from the root of bucket %s?", len(objects), b)
+		}
+		if !confirm(msg) {
+			return errors.New("not confirmed, nothing is deleted (-yes skips the confirmation)")
+		}
+	}
+	return runTasks(&GenDeleteTask{bucket: b, objects: objects})
+}
+
+// confirm asks y/N on stdin. EOF is no, so a pipe never confirms.
+func confirm(msg string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", msg)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// GenDeleteTask generates a DeleteObjects task for each batch of objects.
+type GenDeleteTask struct {
+	bucket  string
+	objects []*s3.Object
+}
+
+func (g *GenDeleteTask) MakeTask(done <-chan struct{}, tasks chan<- pipelines.Task) error {
+	for i := 0; i < len(g.objects); i += deleteBatchSize {
+		end := i + deleteBatchSize
+		if end > len(g.objects) {
+			end = len(g.objects)
+		}
+		t := deleteTask{bucket: g.bucket}
+		for _, o := range g.objects[i:end] {
+			t.keys = append(t.keys, aws.StringValue(o.Key))
+		}
+		select {
+		case tasks <- t:
+		case <-done:
+			return errors.New("Generate Task canceled")
+		}
+	}
+	return nil
+}
+
+type deleteTask struct {
+	bucket string
+	keys   []string
+}
+
+func (t deleteTask) url(key string) string {
+	return "s3://" + t.bucket + "/" + key
+}
+
+func (t deleteTask) Work() pipelines.TaskResult {
+	r := &deleteResult{}
+	del := &s3.Delete{Quiet: aws.Bool(true)}
+	for _, key := range t.keys {
+		del.Objects = append(del.Objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+	}
+	res, err := S3client.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(t.bucket),
+		Delete: del,
+	})
+	if err != nil {
+		for _, key := range t.keys {
+			r.errs = append(r.errs, failure{t.url(key), err.Error()})
+		}
+		return r
+	}
+	failed := map[string]bool{}
+	for _, e := range res.Errors {
+		key := aws.StringValue(e.Key)
+		failed[key] = true
+		r.errs = append(r.errs, failure{t.url(key), aws.StringValue(e.Code) + ": " + aws.StringValue(e.Message)})
+	}
+	for _, key := range t.keys {
+		if !failed[key] {
+			r.deleted = append(r.deleted, t.url(key))
+		}
+	}
+	return r
+}
+
+// deleteResult is the result of a batch, each key fails on its own.
+type deleteResult struct {
+	deleted []string
+	errs    []failure
+}
+
+func (r *deleteResult) Error() string {
+	if len(r.errs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d objects are not deleted", len(r.errs))
+}
+
+func (r *deleteResult) GetMessage() string {
+	return "delete: " + strings.Join(r.deleted, "\ndelete: ")
+}
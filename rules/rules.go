@@ -0,0 +1,161 @@
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Rules file format:
+//
+//	# comment
+//	<match> <value>
+//
+// <match> is a glob pattern (path.Match) or a size condition such as
+// ">=100M" or "<1k". A pattern containing '/' is matched against the
+// relative path, otherwise against the base name. The value is the rest
+// of the line.
+type Rule struct {
+	Pattern string
+	Op      string
+	Size    int64
+	Value   string
+}
+
+type Rules []Rule
+
+var sizeOps = []string{">=", "<=", ">", "<", "="}
+
+func Load(file string) (Rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rs, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return rs, nil
+}
+
+func Parse(r io.Reader) (Rules, error) {
+	rs := Rules{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.IndexAny(text, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing value", line)
+		}
+		rule, err := newRule(text[:i], strings.TrimSpace(text[i:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rs = append(rs, rule)
+	}
+	return rs, scanner.Err()
+}
+
+func newRule(match, value string) (Rule, error) {
+	for _, op := range sizeOps {
+		if strings.HasPrefix(match, op) {
+			size, err := ParseSize(strings.TrimPrefix(match, op))
+			return Rule{Op: op, Size: size, Value: value}, err
+		}
+	}
+	if _, err := path.Match(match, ""); err != nil {
+		return Rule{}, err
+	}
+	return Rule{Pattern: match, Value: value}, nil
+}
+
+// ParseSize parses "1024", "100k", "20M", "1G" or "1T" (1024 based).
+func ParseSize(s string) (int64, error) {
+	unit := int64(1)
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			unit = 1 << 10
+		case "M":
+			unit = 1 << 20
+		case "G":
+			unit = 1 << 30
+		case "T":
+			unit = 1 << 40
+		}
+		if unit > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * unit, nil
+}
+
+func (r Rule) Match(relPath string, size int64) bool {
+	switch r.Op {
+	case ">=":
+		return size >= r.Size
+	case "<=":
+		return size <= r.Size
+	case ">":
+		return size > r.Size
+	case "<":
+		return size < r.Size
+	case "=":
+		return size == r.Size
+	}
+	return MatchPath(r.Pattern, relPath)
+}
+
+// MatchPath matches pattern against relPath, or its base name when the
+// pattern has no '/'.
+func MatchPath(pattern, relPath string) bool {
+	name := relPath
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(relPath)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// Match returns the values of all matching rules in file order.
+func (rs Rules) Match(relPath string, size int64) []string {
+	values := []string{}
+	for _, r := range rs {
+		if r.Match(relPath, size) {
+			values = append(values, r.Value)
+		}
+	}
+	return values
+}
+
+// Values returns the values of all rules.
+func (rs Rules) Values() []string {
+	values := make([]string, len(rs))
+	for i, r := range rs {
+		values[i] = r.Value
+	}
+	return values
+}
+
+// Last returns the value of the last matching rule, or "".
+func (rs Rules) Last(relPath string, size int64) string {
+	values := rs.Match(relPath, size)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
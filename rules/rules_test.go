@@ -0,0 +1,41 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	rs, err := Parse(strings.NewReader(`
+# storage class rules
+*.log STANDARD_IA
+>=1G  DEEP_ARCHIVE
+index/*.idx STANDARD
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		size int64
+		want string
+	}{
+		{"app/access.log", 100, "STANDARD_IA"},
+		{"app/access.log", 2 << 30, "DEEP_ARCHIVE"},
+		{"index/a.idx", 100, "STANDARD"},
+		{"data/a.idx", 100, ""},
+	}
+	for _, tt := range tests {
+		if got := rs.Last(tt.path, tt.size); got != tt.want {
+			t.Errorf("Last(%s, %d) = %q, want %q", tt.path, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, s := range []string{"*.log", ">1X STANDARD", "[ STANDARD"} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("Parse(%q) err = nil", s)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+)
+
+// subcommand is a command given as the first argument such as "s3cp ls".
+// It takes the global options as well as its own.
+type subcommand struct {
+	usage    string
+	flags    func(fs *flag.FlagSet)
+	run      func(args []string) error
+	copyArgs bool // takes the arguments of a copy, checked as those
+}
+
+var subcommands = map[string]*subcommand{}
+
+// parseFlags parses the command line and returns the subcommand, or nil.
+// flag.CommandLine is replaced by the flag set of the subcommand so that
+// flag.Args and the config file work the same way.
+func parseFlags() *subcommand {
+	if len(os.Args) < 2 || subcommands[os.Args[1]] == nil {
+		flag.Parse()
+		return nil
+	}
+	name := os.Args[1]
+	sub := subcommands[name]
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	if sub.flags != nil {
+		sub.flags(fs)
+	}
+	own := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) {
+		own[f.Name] = true
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.Usage = func() {
+		fmt.Printf("Usage:\n")
+		fmt.Printf(" %s %s %s\n", path.Base(os.Args[0]), name, sub.usage)
+		if len(own) > 0 {
+			fmt.Printf("Options:\n")
+			fs.VisitAll(func(f *flag.Flag) {
+				if own[f.Name] {
+					fmt.Printf("  -%s\n    \t%s\n", f.Name, f.Usage)
+				}
+			})
+		}
+		fmt.Printf("Global options:\n")
+		flag.PrintDefaults()
+	}
+	fs.Parse(os.Args[2:])
+	flag.CommandLine = fs
+	return sub
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+var (
+	connectTimeout = 10  // second
+	readTimeout    = 120 // second
+	totalTimeout   = 0   // second
+	accelerate     = false
+	dualstack      = false
+	proxy          = ""
+	caBundle       = ""
+	dumpHTTP       = false
+)
+
+// Headers replaced by dumpHTTP.
+var redactHeaders = []string{
+	"Authorization",
+	"X-Amz-Security-Token",
+	"X-Amz-Server-Side-Encryption-Customer-Key",
+	"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key",
+}
+
+// dumpHandlers adds the handlers of -dump-http. They are session handlers
+// rather than a RoundTripper, as the SDK only loads a CA bundle into an
+// *http.Transport, and so also apply to the STS calls of the credentials.
+func dumpHandlers(h *request.Handlers) {
+	h.Send.PushFrontNamed(request.NamedHandler{Name: "s3cp.DumpRequest", Fn: func(r *request.Request) {
+		req := r.HTTPRequest.Clone(r.HTTPRequest.Context())
+		for _, name := range redactHeaders {
+			if req.Header.Get(name) != "" {
+				req.Header.Set(name, "REDACTED")
+			}
+		}
+		// The bodies are not dumped, they are the file contents.
+		if b, err := httputil.DumpRequestOut(req, false); err == nil {
+			log.Printf("request:\n%s", b)
+		}
+	}})
+	h.Send.PushBackNamed(request.NamedHandler{Name: "s3cp.DumpResponse", Fn: func(r *request.Request) {
+		if r.HTTPResponse == nil || r.HTTPResponse.StatusCode == 0 {
+			log.Printf("response error: %v", r.Error)
+			return
+		}
+		if b, err := httputil.DumpResponse(r.HTTPResponse, false); err == nil {
+			log.Printf("response:\n%s", b)
+		}
+	}})
+}
+
+// caBundleReader opens -ca-bundle for the session, which takes it over
+// AWS_CA_BUNDLE.
+func caBundleReader() (io.Reader, error) {
+	if caBundle == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// idleTimeoutConn fails a read or write that makes no progress for timeout,
+// unlike http.Client.Timeout which limits the whole request.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func newHTTPClient() (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(connectTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	// Each of the workNum files is uploaded by workNum part workers.
+	conns := workNum * workNum
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: time.Duration(connectTimeout) * time.Second,
+		MaxIdleConns:        conns,
+		MaxIdleConnsPerHost: conns,
+		IdleConnTimeout:     90 * time.Second,
+		DialContext:         dialer.DialContext,
+	}
+	if readTimeout > 0 {
+		t.ResponseHeaderTimeout = time.Duration(readTimeout) * time.Second
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &idleTimeoutConn{conn, time.Duration(readTimeout) * time.Second}, nil
+		}
+	}
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{
+		Timeout:   time.Duration(totalTimeout) * time.Second,
+		Transport: t,
+	}, nil
+}
+
+// endpointConfig sets the endpoint options of the S3 client.
+func endpointConfig(conf *aws.Config) {
+	if accelerate {
+		conf.S3UseAccelerate = aws.Bool(true)
+	}
+	if dualstack {
+		conf.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+}
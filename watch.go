@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/masahide/s3cp/pipelines"
+)
+
+var (
+	watch         = false
+	watchDebounce = 2 // second
+	watchRescan   = 0 // minute
+)
+
+// pendingFile is a changed file waiting to settle.
+type pendingFile struct {
+	last  time.Time // last event or change of the stat
+	size  int64
+	mtime time.Time
+}
+
+// GenWatchTask generates upload tasks for the files changed under root,
+// watched by inotify. A file is uploaded when it has had no event for
+// -watch-debounce, and its size and mtime have not changed for another
+// -watch-debounce. With -watch-rescan the whole tree is walked again
+// periodically, and after the event queue overflowed.
+type GenWatchTask struct {
+	root     string
+	destPath string
+	watcher  *fsnotify.Watcher
+	pending  map[string]*pendingFile
+}
+
+// newGenWatchTask starts watching root; the events are queued until MakeTask runs.
+func newGenWatchTask(root, dest string) (*GenWatchTask, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	g := &GenWatchTask{root: root, destPath: dest, watcher: w, pending: map[string]*pendingFile{}}
+	if err = g.addDir(root, false); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// addDir watches dir and its subdirectories. With queue the files found
+// are queued too, as they may have been created before the watch.
+func (g *GenWatchTask) addDir(dir string, queue bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			Log.Warning("watch %s: %v", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			if err := g.watcher.Add(path); err != nil {
+				if path == dir {
+					return err
+				}
+				Log.Warning("watch %s: %v", path, err)
+			}
+		} else if queue && info.Mode().IsRegular() {
+			g.touch(path)
+		}
+		return nil
+	})
+}
+
+func (g *GenWatchTask) touch(path string) {
+	p, ok := g.pending[path]
+	if !ok {
+		p = &pendingFile{size: -1}
+		g.pending[path] = p
+	}
+	p.last = time.Now()
+}
+
+func (g *GenWatchTask) event(ev fsnotify.Event) {
+	if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) == 0 {
+		return // a removed or renamed file has gone
+	}
+	fi, err := os.Lstat(ev.Name)
+	if err != nil {
+		return
+	}
+	if fi.IsDir() {
+		if ev.Op&fsnotify.Create != 0 {
+			if err := g.addDir(ev.Name, true); err != nil {
+				Log.Warning("watch %s: %v", ev.Name, err)
+			}
+		}
+	} else if fi.Mode().IsRegular() {
+		g.touch(ev.Name)
+	}
+}
+
+// flush sends the tasks of the settled files.
+func (g *GenWatchTask) flush(done <-chan struct{}, tasks chan<- pipelines.Task) bool {
+	debounce := time.Duration(watchDebounce) * time.Second
+	now := time.Now()
+	for path, p := range g.pending {
+		if now.Sub(p.last) < debounce {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			delete(g.pending, path)
+			continue
+		}
+		if fi.Size() != p.size || !fi.ModTime().Equal(p.mtime) {
+			// Still being written.
+			p.size, p.mtime, p.last = fi.Size(), fi.ModTime(), now
+			continue
+		}
+		delete(g.pending, path)
+		select {
+		case tasks <- s3cpTask{path: path, root: g.root, dest: g.destPath, queued: keyTime()}:
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
+
+func (g *GenWatchTask) rescan(done <-chan struct{}, tasks chan<- pipelines.Task) error {
+	Log.Info("watch: rescan %s", g.root)
+	return (&GenUploadTask{g.root, g.destPath, Log}).MakeTask(done, tasks)
+}
+
+func (g *GenWatchTask) MakeTask(done <-chan struct{}, tasks chan<- pipelines.Task) error {
+	defer g.watcher.Close()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	var rescan <-chan time.Time
+	if watchRescan > 0 {
+		t := time.NewTicker(time.Duration(watchRescan) * time.Minute)
+		defer t.Stop()
+		rescan = t.C
+	}
+	for {
+		select {
+		case <-done:
+			return nil
+		case ev, ok := <-g.watcher.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			g.event(ev)
+		case err := <-g.watcher.Errors:
+			Log.Warning("watch: %v", err)
+			if err == fsnotify.ErrEventOverflow {
+				if err = g.rescan(done, tasks); err != nil {
+					return err
+				}
+			}
+		case <-tick.C:
+			if !g.flush(done, tasks) {
+				return nil
+			}
+		case <-rescan:
+			if err := g.rescan(done, tasks); err != nil {
+				return err
+			}
+		}
+	}
+}